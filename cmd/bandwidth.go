@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/djeebus/ftpsync/lib"
+	"github.com/djeebus/ftpsync/lib/config"
+)
+
+// buildBandwidthLimiters returns the limiter of every job with a max rate,
+// by job name. Jobs run at the same time, so jobs with the same rate and
+// schedule share one limiter, and the rate caps all of them together.
+func buildBandwidthLimiters(jobs []config.Job) map[string]*lib.BandwidthLimiter {
+	var (
+		limiters = make(map[string]*lib.BandwidthLimiter)
+		shared   = make(map[string]*lib.BandwidthLimiter)
+	)
+
+	for _, job := range jobs {
+		if job.MaxRate <= 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("%d %s", job.MaxRate, job.MaxRateSchedule)
+		limiter, ok := shared[key]
+		if !ok {
+			var active func(time.Time) bool
+			if !job.MaxRateSchedule.IsEmpty() {
+				active = job.MaxRateSchedule.Contains
+			}
+			limiter = lib.NewBandwidthLimiter(int64(job.MaxRate), active)
+			shared[key] = limiter
+		}
+
+		limiters[job.Name] = limiter
+	}
+
+	return limiters
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/djeebus/ftpsync/lib"
+	"github.com/djeebus/ftpsync/lib/config"
+	"github.com/djeebus/ftpsync/lib/sqlite"
+)
+
+func newCheckConfigCmd(selector *jobSelector) *cobra.Command {
+	var offline bool
+
+	cmd := &cobra.Command{
+		Use:   "check-config",
+		Short: "Validate the configuration and make sure every source is reachable",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jobs, err := loadJobs(cmd, *selector)
+			if err != nil {
+				return err
+			}
+
+			for idx, job := range jobs {
+				if idx > 0 {
+					fmt.Fprintln(cmd.OutOrStdout())
+				}
+
+				if err = printConfig(cmd, job); err != nil {
+					return err
+				}
+
+				if offline {
+					continue
+				}
+
+				if err = checkConnections(job); err != nil {
+					return errors.Wrapf(err, "job %s", job.Name)
+				}
+			}
+
+			_, err = fmt.Fprintln(cmd.OutOrStdout(), "\nconfiguration is ok")
+			return err
+		},
+	}
+
+	cmd.Flags().BoolVar(&offline, "offline", false, "only validate the values, don't connect to anything")
+
+	return cmd
+}
+
+func checkConnections(job config.Job) error {
+	log := newLogger(job)
+
+	filter, err := buildFilter(job.Config)
+	if err != nil {
+		return err
+	}
+
+	source, err := buildSource(job.Source, filter, lib.LinkPolicy(job.Links), log)
+	if err != nil {
+		return err
+	}
+	if err = source.Close(); err != nil {
+		log.WithError(err).Warning("failed to close source")
+	}
+
+	if _, ok := job.LocalDestination(); !ok {
+		destination, err := buildDestination(job.Destination, filter, lib.LinkPolicy(job.Links), log)
+		if err != nil {
+			return err
+		}
+		if closer, ok := destination.(io.Closer); ok {
+			if err = closer.Close(); err != nil {
+				log.WithError(err).Warning("failed to close destination")
+			}
+		}
+	}
+
+	database, err := sqlite.NewReadOnly(job.Database)
+	if err != nil {
+		return errors.Wrap(err, "failed to open database")
+	}
+	defer database.Close()
+
+	if _, err = database.GetAllFiles(job.RootDir); err != nil {
+		return errors.Wrap(err, "failed to read database")
+	}
+
+	return nil
+}
+
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+
+	// webdav takes bearer tokens in the query
+	if query := u.Query(); query.Has("token") {
+		query.Set("token", "xxxxx")
+		u.RawQuery = query.Encode()
+	}
+
+	return u.Redacted()
+}
+
+func printConfig(cmd *cobra.Command, job config.Job) error {
+	table := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+
+	rows := [][2]string{
+		{"job", job.Name},
+		{"database", job.Database},
+		{"source", redactURL(job.Source)},
+		{"precheck", redactURL(job.Precheck)},
+		{"precheck ttl", job.PrecheckTTL.String()},
+		{"destination", redactURL(job.Destination)},
+		{"direction", job.Direction},
+		{"root dir", job.RootDir},
+		{"repeat", job.Repeat.String()},
+		{"concurrency", fmt.Sprint(job.Concurrency)},
+		{"verify checksums", fmt.Sprint(job.VerifyChecksums || job.VerifyLocal)},
+		{"verify local", fmt.Sprint(job.VerifyLocal)},
+		{"preserve mtime", fmt.Sprint(job.PreserveModTime)},
+		{"max rate", job.MaxRate.String()},
+		{"max rate schedule", job.MaxRateSchedule.String()},
+		{"retention", job.Retention.String()},
+		{"include", strings.Join(job.Include, ",")},
+		{"exclude", strings.Join(job.Exclude, ",")},
+		{"include regex", job.IncludeRegex},
+		{"exclude regex", job.ExcludeRegex},
+		{"min size", job.MinSize.String()},
+		{"max size", job.MaxSize.String()},
+		{"exclude hidden", fmt.Sprint(job.ExcludeHidden)},
+		{"links", job.Links},
+		{"path rules", strings.Join(job.PathRules, ";")},
+		{"extract", fmt.Sprint(job.Extract)},
+		{"max deletes", fmt.Sprint(job.MaxDeletes)},
+		{"max delete percent", fmt.Sprint(job.MaxDeletePercent)},
+		{"force", fmt.Sprint(job.Force)},
+		{"trash dir", job.TrashDir},
+		{"trash retention", job.TrashRetention.String()},
+		{"file hook command", job.FileHookCommand},
+		{"file hook url", redactURL(job.FileHookURL)},
+		{"sync hook command", job.SyncHookCommand},
+		{"sync hook url", redactURL(job.SyncHookURL)},
+		{"hook timeout", job.HookTimeout.String()},
+		{"metrics addr", job.MetricsAddr},
+		{"dir mode", fmt.Sprintf("%#o", uint32(job.DirMode))},
+		{"file mode", fmt.Sprintf("%#o", uint32(job.FileMode))},
+		{"log level", job.LogLevel.String()},
+		{"log format", job.LogFormat},
+	}
+
+	for _, row := range rows {
+		if _, err := fmt.Fprintf(table, "%s\t%s\n", row[0], row[1]); err != nil {
+			return err
+		}
+	}
+
+	return table.Flush()
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/djeebus/ftpsync/lib"
+	"github.com/djeebus/ftpsync/lib/config"
+	"github.com/djeebus/ftpsync/lib/sqlite"
+)
+
+func newDbCmd(selector *jobSelector) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Inspect and edit the record of downloaded files",
+	}
+
+	cmd.AddCommand(
+		newDbListCmd(selector),
+		newDbForgetCmd(selector),
+		newDbImportCmd(selector),
+	)
+
+	return cmd
+}
+
+func newDbListCmd(selector *jobSelector) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list [prefix]",
+		Short: "List recorded files, optionally only those under prefix",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := loadJob(cmd, *selector)
+			if err != nil {
+				return err
+			}
+
+			prefix := job.RootDir
+			if len(args) == 1 {
+				prefix = args[0]
+			}
+
+			database, err := sqlite.NewReadOnly(job.Database)
+			if err != nil {
+				return errors.Wrap(err, "failed to open database")
+			}
+			defer database.Close()
+
+			files, err := database.GetAllFiles(prefix)
+			if err != nil {
+				return err
+			}
+
+			paths := files.ToList()
+			sort.Strings(paths)
+
+			for _, path := range paths {
+				if _, err = fmt.Fprintln(cmd.OutOrStdout(), path); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func newDbForgetCmd(selector *jobSelector) *cobra.Command {
+	return &cobra.Command{
+		Use:   "forget <path>...",
+		Short: "Remove files from the record, so the next sync treats them as new",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := loadJob(cmd, *selector)
+			if err != nil {
+				return err
+			}
+
+			database, err := sqlite.New(job.Database)
+			if err != nil {
+				return errors.Wrap(err, "failed to open database")
+			}
+			defer database.Close()
+
+			for _, path := range args {
+				ok, err := database.Exists(path)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("%s is not recorded", path)
+				}
+
+				if err = database.Delete(path); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func newDbImportCmd(selector *jobSelector) *cobra.Command {
+	return &cobra.Command{
+		Use:   "import [file]",
+		Short: "Record files as downloaded",
+		Long: `Record files as downloaded.
+
+With no arguments, every file under the root dir of the destination is
+recorded. Otherwise paths are read from file, one per line, or from stdin
+if file is "-".`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			job, err := loadJob(cmd, *selector)
+			if err != nil {
+				return err
+			}
+
+			var paths []string
+			if len(args) == 1 {
+				paths, err = readPaths(cmd.InOrStdin(), args[0])
+			} else {
+				paths, err = readDestinationPaths(job)
+			}
+			if err != nil {
+				return err
+			}
+
+			database, err := sqlite.New(job.Database)
+			if err != nil {
+				return errors.Wrap(err, "failed to open database")
+			}
+			defer database.Close()
+
+			for _, path := range paths {
+				if err = database.Record(path); err != nil {
+					return err
+				}
+			}
+
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "recorded %d files\n", len(paths))
+			return err
+		},
+	}
+}
+
+func readPaths(stdin io.Reader, filename string) ([]string, error) {
+	reader := stdin
+	if filename != "-" {
+		fp, err := os.Open(filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open %s", filename)
+		}
+		defer fp.Close()
+		reader = fp
+	}
+
+	var paths []string
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		if path := strings.TrimSpace(scanner.Text()); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", filename)
+	}
+
+	return paths, nil
+}
+
+func readDestinationPaths(job config.Job) ([]string, error) {
+	filter, err := buildFilter(job.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	var destination lib.Destination
+	destination, err = buildLocal(job.Config, filter, newLogger(job))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build destination")
+	}
+	if closer, ok := destination.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	files, err := destination.GetAllFiles(job.RootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := files.ToSet().ToList()
+	sort.Strings(paths)
+
+	return paths, nil
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/djeebus/ftpsync/lib"
+	"github.com/djeebus/ftpsync/lib/config"
+	"github.com/djeebus/ftpsync/lib/hooks"
+)
+
+// buildHooks returns the hooks for job, or nil if it doesn't have any.
+func buildHooks(job config.Job) lib.Hook {
+	if job.FileHookCommand == "" && job.FileHookURL == "" && job.SyncHookCommand == "" && job.SyncHookURL == "" {
+		return nil
+	}
+
+	opts := hooks.Options{
+		Job:         job.Name,
+		FileCommand: job.FileHookCommand,
+		FileURL:     job.FileHookURL,
+		SyncCommand: job.SyncHookCommand,
+		SyncURL:     job.SyncHookURL,
+		Timeout:     job.HookTimeout,
+	}
+	if root, ok := job.LocalDestination(); ok && !job.IsUpload() {
+		// uploads and remote destinations are written to a server, which
+		// has no local path
+		opts.LocalRoot = root
+	}
+
+	return hooks.New(opts)
+}
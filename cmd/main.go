@@ -2,41 +2,257 @@ package cmd
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/djeebus/ftpsync/lib"
 	"github.com/djeebus/ftpsync/lib/config"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// envAnnotation marks flags that override a FTPSYNC_* setting; its value is
+// the name of the setting, minus the prefix.
+const envAnnotation = "ftpsync_env"
+
 func RootCmd() error {
 	ctx := context.Background()
 	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	// only used to show the current values as flag defaults. Commands that
+	// need the config read it again and report the error, and version and
+	// check-config have to run even when it's broken.
 	cfg, err := config.ReadConfig()
 	if err != nil {
-		return errors.Wrap(err, "failed to get config")
+		cfg = config.DefaultConfig()
+	}
+
+	return newRootCmd(cfg).ExecuteContext(ctx)
+}
+
+type jobSelector struct {
+	configPath string
+	names      []string
+}
+
+func newRootCmd(cfg config.Config) *cobra.Command {
+	var selector jobSelector
+
+	root := &cobra.Command{
+		Use:   "ftpsync",
+		Short: "Mirror a remote server to a local directory",
+		Long: `Mirror a remote ftp, sftp, filebrowser, webdav or s3 server, or a web
+server's directory listings, to a local directory.
+
+Every flag defaults to the matching FTPSYNC_* environment variable. Running
+ftpsync without a subcommand is the same as running "ftpsync sync".
+
+Several jobs can be defined in a YAML or TOML file passed with --config.
+Environment variables and flags override the file for every job.`,
+		Args:          cobra.NoArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSync(cmd, selector)
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&selector.configPath, "config", os.Getenv("FTPSYNC_CONFIG"), "YAML or TOML file defining the jobs to run (FTPSYNC_CONFIG)")
+	flags.StringSliceVar(&selector.names, "job", nil, "only use these jobs from the config file")
+	envFlag(flags, "database", "DATABASE", cfg.Database, "path to the sqlite database")
+	envFlag(flags, "source", "SOURCE", cfg.Source, "url of the server to mirror")
+	envFlag(flags, "precheck", "PRECHECK", cfg.Precheck, "url of the deluge, qbittorrent or transmission client to check before downloading")
+	flags.Duration("precheck-ttl", cfg.PrecheckTTL, "how long to cache the torrent client's downloads (FTPSYNC_PRECHECK_TTL)")
+	markEnvFlag(flags, "precheck-ttl", "PRECHECK_TTL")
+	envFlag(flags, "destination", "DESTINATION", cfg.Destination, "local directory, or file, ftp, sftp, filebrowser or s3 url, to mirror to")
+	envFlag(flags, "direction", "DIRECTION", cfg.Direction, "download from the source, upload to it, or both")
+	envFlag(flags, "root-dir", "ROOT_DIR", cfg.RootDir, "directory to mirror, relative to the source and destination")
+	envFlag(flags, "log-format", "LOG_FORMAT", cfg.LogFormat, "text or json")
+	envFlag(flags, "log-level", "LOG_LEVEL", cfg.LogLevel.String(), "minimum level to log")
+
+	addSyncFlags(root.Flags(), cfg)
+
+	root.AddCommand(
+		newSyncCmd(cfg, &selector),
+		newPlanCmd(cfg, &selector),
+		newDbCmd(&selector),
+		newCheckConfigCmd(&selector),
+		newVersionCmd(),
+	)
+
+	return root
+}
+
+// envFlag adds a string flag that overrides the FTPSYNC_<key> setting.
+func envFlag(flags *pflag.FlagSet, name, key, value, usage string) {
+	flags.String(name, value, fmt.Sprintf("%s (FTPSYNC_%s)", usage, key))
+	markEnvFlag(flags, name, key)
+}
+
+func markEnvFlag(flags *pflag.FlagSet, name, key string) {
+	if err := flags.SetAnnotation(name, envAnnotation, []string{key}); err != nil {
+		panic(err)
+	}
+}
+
+// loadJobs reads the selected jobs, with any flags that were passed on the
+// command line overriding their settings.
+func loadJobs(cmd *cobra.Command, selector jobSelector) ([]config.Job, error) {
+	overrides := make(map[string]string)
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if keys, ok := flag.Annotations[envAnnotation]; ok {
+			overrides[keys[0]] = flag.Value.String()
+		}
+	})
+
+	jobs, err := config.ReadJobs(selector.configPath, overrides)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get config")
+	}
+
+	if len(selector.names) > 0 {
+		var selected []config.Job
+		for _, name := range selector.names {
+			idx := findJob(jobs, name)
+			if idx == -1 {
+				return nil, fmt.Errorf("unknown job: %s", name)
+			}
+			selected = append(selected, jobs[idx])
+		}
+		jobs = selected
+	}
+
+	for _, job := range jobs {
+		if err = job.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid job %s", job.Name)
+		}
+		if _, err = buildFilter(job.Config); err != nil {
+			return nil, errors.Wrapf(err, "invalid job %s", job.Name)
+		}
+		if len(legacyExcludes(job.Source)) > 0 {
+			newLogger(job).Warning("the excluded query parameter is deprecated, use --exclude instead")
+		}
+		if _, err = lib.NewPathMapper(job.PathRules); err != nil {
+			return nil, errors.Wrapf(err, "invalid job %s", job.Name)
+		}
+	}
+
+	return jobs, nil
+}
+
+func findJob(jobs []config.Job, name string) int {
+	for idx, job := range jobs {
+		if job.Name == name {
+			return idx
+		}
 	}
+	return -1
+}
 
-	if cfg.Source == "" {
-		return errors.New("must define a source")
+// loadJob is loadJobs for commands that only work on one job at a time.
+func loadJob(cmd *cobra.Command, selector jobSelector) (config.Job, error) {
+	jobs, err := loadJobs(cmd, selector)
+	if err != nil {
+		return config.Job{}, err
 	}
-	if cfg.Destination == "" {
-		return errors.New("must define a precheck")
+
+	if len(jobs) != 1 {
+		return config.Job{}, errors.New("more than one job is configured, pick one with --job")
 	}
 
+	return jobs[0], nil
+}
+
+func newLogger(job config.Job) logrus.FieldLogger {
 	log := logrus.New()
-	log.SetLevel(cfg.LogLevel)
-	if strings.ToLower(cfg.LogFormat) == "json" {
+	log.SetLevel(job.LogLevel)
+	if strings.ToLower(job.LogFormat) == "json" {
 		log.SetFormatter(&logrus.JSONFormatter{})
 	}
 
-	if cfg.Repeat != 0 {
-		if err = doSync(cfg, log); err != nil {
+	if job.Name == config.DefaultJobName {
+		return log
+	}
+	return log.WithField("job", job.Name)
+}
+
+func runSync(cmd *cobra.Command, selector jobSelector) error {
+	jobs, err := loadJobs(cmd, selector)
+	if err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+
+	registry := startMetrics(ctx, jobs)
+	limiters := buildBandwidthLimiters(jobs)
+	jobOpts := func(job config.Job) []lib.Option {
+		var opts []lib.Option
+		if registry != nil {
+			opts = append(opts, lib.WithMetrics(registry.ForJob(job.Name)))
+		}
+		if limiter, ok := limiters[job.Name]; ok {
+			opts = append(opts, lib.WithBandwidthLimit(limiter))
+		}
+		if hook := buildHooks(job); hook != nil && !job.DryRun {
+			opts = append(opts, lib.WithHooks(hook))
+		}
+		return opts
+	}
+
+	if len(jobs) == 1 {
+		return runJob(ctx, jobs[0], cmd.OutOrStdout(), jobOpts(jobs[0])...)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		failed = make([]bool, len(jobs))
+	)
+
+	for idx, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runJob(ctx, job, cmd.OutOrStdout(), jobOpts(job)...); err != nil && ctx.Err() == nil {
+				newLogger(job).WithError(err).Error("job failed")
+				failed[idx] = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var count int
+	for _, isFailed := range failed {
+		if isFailed {
+			count++
+		}
+	}
+	if count > 0 {
+		return fmt.Errorf("%d of %d jobs failed", count, len(jobs))
+	}
+
+	return nil
+}
+
+func runJob(ctx context.Context, job config.Job, out io.Writer, opts ...lib.Option) error {
+	log := newLogger(job)
+
+	if job.Repeat != 0 && !job.DryRun {
+		if err := doSync(job.Config, log, out, opts...); err != nil {
 			log.WithError(err).Warning("failed to process")
 		}
 
@@ -44,13 +260,13 @@ func RootCmd() error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(cfg.Repeat):
-				if err = doSync(cfg, log); err != nil {
+			case <-time.After(job.Repeat):
+				if err := doSync(job.Config, log, out, opts...); err != nil {
 					log.WithError(err).Warning("failed to process")
 				}
 			}
 		}
 	}
 
-	return doSync(cfg, log)
+	return doSync(job.Config, log, out, opts...)
 }
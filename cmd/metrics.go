@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/djeebus/ftpsync/lib/config"
+	"github.com/djeebus/ftpsync/lib/metrics"
+)
+
+// startMetrics serves metrics on every address the jobs ask for, until ctx
+// is done. It returns nil if none of them do.
+func startMetrics(ctx context.Context, jobs []config.Job) *metrics.Registry {
+	var (
+		registry *metrics.Registry
+		started  = make(map[string]bool)
+	)
+
+	for _, job := range jobs {
+		if job.MetricsAddr == "" || job.DryRun || started[job.MetricsAddr] {
+			continue
+		}
+		started[job.MetricsAddr] = true
+
+		if registry == nil {
+			registry = metrics.New()
+		}
+
+		go serveMetrics(ctx, job.MetricsAddr, registry, newLogger(job))
+	}
+
+	return registry
+}
+
+func serveMetrics(ctx context.Context, addr string, registry *metrics.Registry, log logrus.FieldLogger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.WithField("addr", addr).Info("serving metrics")
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.WithError(err).Error("failed to serve metrics")
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/djeebus/ftpsync/lib/config"
+)
+
+func newPlanCmd(cfg config.Config, selector *jobSelector) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Print what a sync would do, without changing anything",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jobs, err := loadJobs(cmd, *selector)
+			if err != nil {
+				return err
+			}
+
+			for _, job := range jobs {
+				if len(jobs) > 1 {
+					fmt.Fprintf(cmd.OutOrStdout(), "# %s\n", job.Name)
+				}
+
+				job.DryRun = true
+				if err = doSync(job.Config, newLogger(job), cmd.OutOrStdout()); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.Bool("verify-local", cfg.VerifyLocal, "re-hash local files to find ones that changed, implies --verify-checksums (FTPSYNC_VERIFY_LOCAL)")
+	markEnvFlag(flags, "verify-local", "VERIFY_LOCAL")
+	flags.StringP("format", "o", cfg.PlanFormat, "text or json (FTPSYNC_PLAN_FORMAT)")
+	markEnvFlag(flags, "format", "PLAN_FORMAT")
+
+	return cmd
+}
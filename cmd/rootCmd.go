@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
 	"net/url"
+	"strings"
 
 	"github.com/djeebus/ftpsync/lib/config"
 	"github.com/djeebus/ftpsync/lib/deluge"
@@ -9,67 +12,317 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/djeebus/ftpsync/lib"
+	"github.com/djeebus/ftpsync/lib/autoindex"
 	"github.com/djeebus/ftpsync/lib/filebrowser"
 	"github.com/djeebus/ftpsync/lib/ftp"
 	"github.com/djeebus/ftpsync/lib/localfs"
+	"github.com/djeebus/ftpsync/lib/qbittorrent"
+	"github.com/djeebus/ftpsync/lib/s3"
+	"github.com/djeebus/ftpsync/lib/sftp"
 	"github.com/djeebus/ftpsync/lib/sqlite"
+	"github.com/djeebus/ftpsync/lib/torrent"
+	"github.com/djeebus/ftpsync/lib/transmission"
+	"github.com/djeebus/ftpsync/lib/webdav"
 )
 
-func doSync(config config.Config, log logrus.FieldLogger) error {
+// buildFilter returns the filter shared by both sides of the sync.
+func buildFilter(config config.Config) (*lib.Filter, error) {
+	return lib.NewFilter(lib.FilterOptions{
+		Include:       config.Include,
+		Exclude:       config.Exclude,
+		IncludeRegex:  config.IncludeRegex,
+		ExcludeRegex:  config.ExcludeRegex,
+		MinSize:       int64(config.MinSize),
+		MaxSize:       int64(config.MaxSize),
+		ExcludeHidden: config.ExcludeHidden,
+		ServerExclude: legacyExcludes(config.Source),
+		Root:          config.RootDir,
+	})
+}
+
+// legacyExcludes returns the globs in the deprecated excluded query
+// parameter of filebrowser urls. They match the whole path on the server,
+// unlike Exclude, so they're kept apart to exclude the same files as before.
+func legacyExcludes(source string) []string {
+	srcURL, err := url.Parse(source)
+	if err != nil || srcURL.Scheme != "filebrowser" {
+		return nil
+	}
+
+	return srcURL.Query()["excluded"]
+}
+
+func buildSource(source string, filter *lib.Filter, links lib.LinkPolicy, log logrus.FieldLogger) (lib.Source, error) {
+	srcURL, err := url.Parse(source)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to parse url")
+	}
+
+	switch srcURL.Scheme {
+	case "ftp", "ftps", "ftps-implicit":
+		src, err := ftp.New(srcURL, filter, links)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build ftp source")
+		}
+		return src, nil
+	case "sftp":
+		src, err := sftp.New(srcURL, filter, links, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build sftp source")
+		}
+		return src, nil
+	case "filebrowser":
+		src, err := filebrowser.New(srcURL, filter, links, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build filebrowser source")
+		}
+		return src, nil
+	case "webdav", "webdavs":
+		src, err := webdav.New(srcURL, filter, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build webdav source")
+		}
+		return src, nil
+	case "s3":
+		src, err := s3.New(srcURL, filter, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build s3 source")
+		}
+		return src, nil
+	case "http", "https":
+		src, err := autoindex.New(srcURL, filter, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build http source")
+		}
+		return src, nil
+	default:
+		return nil, errors.New("unknown source")
+	}
+}
+
+func buildDestination(destination string, filter *lib.Filter, links lib.LinkPolicy, log logrus.FieldLogger) (lib.Destination, error) {
+	dstURL, err := url.Parse(destination)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to parse url")
+	}
+
+	switch dstURL.Scheme {
+	case "ftp", "ftps", "ftps-implicit":
+		dst, err := ftp.NewDestination(dstURL, filter, links)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build ftp destination")
+		}
+		return dst, nil
+	case "sftp":
+		dst, err := sftp.NewDestination(dstURL, filter, links, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build sftp destination")
+		}
+		return dst, nil
+	case "filebrowser":
+		dst, err := filebrowser.New(dstURL, filter, links, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build filebrowser destination")
+		}
+		return dst, nil
+	case "s3":
+		dst, err := s3.New(dstURL, filter, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to build s3 destination")
+		}
+		return dst, nil
+	default:
+		return nil, fmt.Errorf("uploading to %s is not supported", dstURL.Scheme)
+	}
+}
+
+// buildLocal returns the folder the sync mirrors, which is on another
+// server if config.Destination is a url.
+func buildLocal(config config.Config, filter *lib.Filter, log logrus.FieldLogger) (lib.Destination, error) {
+	if _, ok := config.LocalDestination(); !ok {
+		return buildDestination(config.Destination, filter, lib.LinkPolicy(config.Links), log)
+	}
+
+	local, err := localfs.New(config, filter, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build local folder")
+	}
+
+	return local, nil
+}
+
+// buildEnds returns where files are read from and written to. Uploads
+// swap the two, so the local folder is read and the server is written.
+func buildEnds(config config.Config, log logrus.FieldLogger) (lib.Source, lib.Destination, lib.SourceFactory, error) {
+	filter, err := buildFilter(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	local, err := buildLocal(config, filter, log)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if config.IsUpload() || config.IsTwoWay() {
+		remote, err := buildDestination(config.Source, filter, lib.LinkPolicy(config.Links), log)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if config.Concurrency > 1 {
+			// workers share the destination, which most servers can't do
+			log.Warning("uploads are not concurrent, ignoring concurrency")
+		}
+
+		if config.IsTwoWay() {
+			// the processor reads from and writes to both ends
+			reader, ok := remote.(lib.Source)
+			if !ok {
+				return nil, nil, nil, fmt.Errorf("two-way sync can't read from %s", redactURL(config.Source))
+			}
+			return reader, local, nil, nil
+		}
+
+		reader, ok := local.(lib.Source)
+		if !ok {
+			return nil, nil, nil, errors.New("only downloads can write to a remote destination")
+		}
+
+		return reader, remote, nil, nil
+	}
+
+	source, err := buildSource(config.Source, filter, lib.LinkPolicy(config.Links), log)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if _, ok := config.LocalDestination(); !ok {
+		if config.Concurrency > 1 {
+			// workers would share the destination, which most servers can't do
+			log.Warning("downloads to a server are not concurrent, ignoring concurrency")
+		}
+		return source, local, nil, nil
+	}
+
+	newSource := func() (lib.Source, error) {
+		return buildSource(config.Source, filter, lib.LinkPolicy(config.Links), log)
+	}
+
+	return source, local, newSource, nil
+}
+
+// buildPrecheck connects to the torrent client in config.Precheck.
+func buildPrecheck(config config.Config, log logrus.FieldLogger) (lib.Precheck, error) {
+	precheckURL, err := url.Parse(config.Precheck)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse precheck")
+	}
+
+	var client torrent.Client
+	switch precheckURL.Scheme {
+	case "deluge", "deluges":
+		client, err = deluge.New(log, precheckURL)
+	case "qbittorrent", "qbittorrents":
+		client, err = qbittorrent.New(log, precheckURL)
+	case "transmission", "transmissions":
+		client, err = transmission.New(log, precheckURL)
+	default:
+		return nil, fmt.Errorf("unknown precheck scheme: %s", precheckURL.Scheme)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s precheck", precheckURL.Scheme)
+	}
+
+	precheck, err := torrent.New(log, client, config.RootDir, config.PrecheckTTL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s precheck", precheckURL.Scheme)
+	}
+
+	return precheck, nil
+}
+
+// doSync runs a single sync of config, with opts added to the ones built
+// from config. Dry runs write their plan to out.
+func doSync(config config.Config, log logrus.FieldLogger, out io.Writer, extraOpts ...lib.Option) error {
 	var (
 		err         error
-		precheckURL *url.URL
-		srcURL      *url.URL
 		source      lib.Source
 		precheck    lib.Precheck
 		database    lib.Database
 		destination lib.Destination
+		newSource   lib.SourceFactory
 	)
 
+	if source, destination, newSource, err = buildEnds(config, log); err != nil {
+		return err
+	}
+	defer source.Close()
+	if closer, ok := destination.(io.Closer); ok {
+		defer closer.Close()
+	}
+
 	if config.Precheck != "" {
-		precheckURL, err = url.Parse(config.Precheck)
-		if err != nil {
-			return errors.Wrap(err, "failed to parse precheck")
+		if precheck, err = buildPrecheck(config, log); err != nil {
+			return err
 		}
+		defer precheck.Close()
 	}
 
-	srcURL, err = url.Parse(config.Source)
+	if config.DryRun {
+		database, err = sqlite.NewReadOnly(config.Database)
+	} else {
+		database, err = sqlite.New(config.Database)
+	}
 	if err != nil {
-		return errors.Wrap(err, "fail to parse url")
+		return errors.Wrap(err, "failed to build database")
 	}
+	defer database.Close()
 
-	switch srcURL.Scheme {
-	case "ftp", "ftps", "sftp":
-		if source, err = ftp.New(srcURL); err != nil {
-			return errors.Wrap(err, "failed to build ftp source")
-		}
-	case "filebrowser":
-		if source, err = filebrowser.New(srcURL, log); err != nil {
-			return errors.Wrap(err, "failed to build filebrowser source")
-		}
-	default:
-		return errors.New("unknown source")
+	opts := []lib.Option{
+		lib.WithConcurrency(config.Concurrency, newSource),
+	}
+	if config.VerifyChecksums {
+		opts = append(opts, lib.WithChecksumVerification())
+	}
+	if config.VerifyLocal {
+		opts = append(opts, lib.WithLocalVerification())
+	}
+	if config.PreserveModTime {
+		opts = append(opts, lib.WithPreservedModTimes())
 	}
-	defer source.Close()
 
-	if precheckURL != nil {
-		switch precheckURL.Scheme {
-		case "deluge", "deluges":
-			if precheck, err = deluge.New(log, precheckURL, config.RootDir); err != nil {
-				return errors.Wrap(err, "failed to create deluge precheck")
-			}
-			defer precheck.Close()
+	if !config.Force && (config.MaxDeletes > 0 || config.MaxDeletePercent > 0) {
+		opts = append(opts, lib.WithDeletionLimit(config.MaxDeletes, config.MaxDeletePercent))
+	}
+
+	if len(config.PathRules) > 0 {
+		mapper, err := lib.NewPathMapper(config.PathRules)
+		if err != nil {
+			return err
 		}
+		opts = append(opts, lib.WithPathMapper(mapper))
 	}
 
-	if database, err = sqlite.New(config.Database); err != nil {
-		return errors.Wrap(err, "failed to build database")
+	if config.Extract {
+		opts = append(opts, lib.WithArchiveExtraction())
+	}
+
+	if config.Retention.Keep {
+		opts = append(opts, lib.WithRetention(config.Retention.For))
 	}
-	if destination, err = localfs.New(config, log); err != nil {
-		return errors.Wrap(err, "failed to build destination")
+
+	if config.IsTwoWay() {
+		opts = append(opts, lib.WithTwoWaySync())
 	}
 
-	processor := lib.BuildProcessor(source, database, precheck, destination, log)
+	opts = append(opts, extraOpts...)
+
+	processor := lib.BuildProcessor(source, database, precheck, destination, log, opts...)
+
+	if config.DryRun {
+		return writePlan(out, processor, config)
+	}
 
 	if err := processor.Process(config.RootDir); err != nil {
 		return err
@@ -77,3 +330,19 @@ func doSync(config config.Config, log logrus.FieldLogger) error {
 
 	return nil
 }
+
+func writePlan(out io.Writer, processor *lib.Processor, config config.Config) error {
+	plan, err := processor.Plan(config.RootDir)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(config.PlanFormat) {
+	case "json":
+		return plan.WriteJSON(out)
+	case "text", "":
+		return plan.WriteText(out)
+	default:
+		return fmt.Errorf("unknown plan format: %s", config.PlanFormat)
+	}
+}
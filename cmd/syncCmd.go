@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/djeebus/ftpsync/lib/config"
+)
+
+func addSyncFlags(flags *pflag.FlagSet, cfg config.Config) {
+	flags.Duration("repeat", cfg.Repeat, "sync again after this long, forever (FTPSYNC_REPEAT)")
+	markEnvFlag(flags, "repeat", "REPEAT")
+	flags.Int("concurrency", cfg.Concurrency, "number of files to download at once (FTPSYNC_CONCURRENCY)")
+	markEnvFlag(flags, "concurrency", "CONCURRENCY")
+	flags.Bool("verify-checksums", cfg.VerifyChecksums, "compare checksums after every download (FTPSYNC_VERIFY_CHECKSUMS)")
+	markEnvFlag(flags, "verify-checksums", "VERIFY_CHECKSUMS")
+	flags.Bool("verify-local", cfg.VerifyLocal, "re-hash local files and download them again if they changed, implies --verify-checksums (FTPSYNC_VERIFY_LOCAL)")
+	markEnvFlag(flags, "verify-local", "VERIFY_LOCAL")
+	flags.Bool("preserve-mtime", cfg.PreserveModTime, "set the modification time of downloads to match the source (FTPSYNC_PRESERVE_MTIME)")
+	markEnvFlag(flags, "preserve-mtime", "PRESERVE_MTIME")
+	envFlag(flags, "max-rate", "MAX_RATE", cfg.MaxRate.String(), "combined download speed limit, like 5MB/s")
+	envFlag(flags, "max-rate-schedule", "MAX_RATE_SCHEDULE", cfg.MaxRateSchedule.String(), "times of day the speed limit applies, like 08:00-23:00")
+	envFlag(flags, "retention", "RETENTION", cfg.Retention.String(), "what to do with files removed from the source: mirror, keep or keep-for=30d")
+	envFlag(flags, "include", "INCLUDE", strings.Join(cfg.Include, ","), "only sync files matching these comma separated globs")
+	envFlag(flags, "exclude", "EXCLUDE", strings.Join(cfg.Exclude, ","), "skip files matching these comma separated globs")
+	envFlag(flags, "include-regex", "INCLUDE_REGEX", cfg.IncludeRegex, "only sync files whose path matches this regex")
+	envFlag(flags, "exclude-regex", "EXCLUDE_REGEX", cfg.ExcludeRegex, "skip files whose path matches this regex")
+	envFlag(flags, "min-size", "MIN_SIZE", cfg.MinSize.String(), "skip files smaller than this, like 1MB")
+	envFlag(flags, "max-size", "MAX_SIZE", cfg.MaxSize.String(), "skip files larger than this, like 50GB")
+	flags.Bool("exclude-hidden", cfg.ExcludeHidden, "skip files and folders starting with a dot (FTPSYNC_EXCLUDE_HIDDEN)")
+	markEnvFlag(flags, "exclude-hidden", "EXCLUDE_HIDDEN")
+	envFlag(flags, "links", "LINKS", cfg.Links, "what to do with symlinks: skip, follow or recreate")
+	envFlag(flags, "path-rules", "PATH_RULES", strings.Join(cfg.PathRules, ";"), "semicolon separated rules to rename files with, like strip=1;*.mkv=video/")
+	flags.Bool("extract", cfg.Extract, "unpack downloaded zip, tar and rar archives next to them (FTPSYNC_EXTRACT)")
+	markEnvFlag(flags, "extract", "EXTRACT")
+	flags.Int("max-deletes", cfg.MaxDeletes, "stop before deleting more than this many local files (FTPSYNC_MAX_DELETES)")
+	markEnvFlag(flags, "max-deletes", "MAX_DELETES")
+	flags.Float64("max-delete-percent", cfg.MaxDeletePercent, "stop before deleting more than this percent of local files (FTPSYNC_MAX_DELETE_PERCENT)")
+	markEnvFlag(flags, "max-delete-percent", "MAX_DELETE_PERCENT")
+	flags.Bool("force", cfg.Force, "delete files even if there are more than the deletion limits allow (FTPSYNC_FORCE)")
+	markEnvFlag(flags, "force", "FORCE")
+	envFlag(flags, "trash-dir", "TRASH_DIR", cfg.TrashDir, "move deleted local files here instead of removing them")
+	envFlag(flags, "trash-retention", "TRASH_RETENTION", cfg.TrashRetention.String(), "purge the trash of files deleted this long ago, like 30d")
+	envFlag(flags, "file-hook-command", "FILE_HOOK_COMMAND", cfg.FileHookCommand, "run this with sh after every download")
+	envFlag(flags, "file-hook-url", "FILE_HOOK_URL", cfg.FileHookURL, "post every download to this url")
+	envFlag(flags, "sync-hook-command", "SYNC_HOOK_COMMAND", cfg.SyncHookCommand, "run this with sh after every sync")
+	envFlag(flags, "sync-hook-url", "SYNC_HOOK_URL", cfg.SyncHookURL, "post every sync to this url")
+	flags.Duration("hook-timeout", cfg.HookTimeout, "give up on hooks after this long (FTPSYNC_HOOK_TIMEOUT)")
+	markEnvFlag(flags, "hook-timeout", "HOOK_TIMEOUT")
+	envFlag(flags, "metrics-addr", "METRICS_ADDR", cfg.MetricsAddr, "serve prometheus metrics on this address, like :9090")
+	flags.Bool("dry-run", cfg.DryRun, "print the plan without changing anything (FTPSYNC_DRY_RUN)")
+	markEnvFlag(flags, "dry-run", "DRY_RUN")
+	envFlag(flags, "plan-format", "PLAN_FORMAT", cfg.PlanFormat, "format of the dry run plan: text or json")
+}
+
+func newSyncCmd(cfg config.Config, selector *jobSelector) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Mirror the source to the destination",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSync(cmd, *selector)
+		},
+	}
+
+	addSyncFlags(cmd.Flags(), cfg)
+
+	return cmd
+}
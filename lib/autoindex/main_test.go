@@ -0,0 +1,167 @@
+package autoindex
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const nginxRoot = `<html>
+<head><title>Index of /pub/</title></head>
+<body>
+<h1>Index of /pub/</h1><hr><pre><a href="../">../</a>
+<a href="movies/">movies/</a>                                            02-Jan-2024 03:04       -
+<a href="hello%20world.txt">hello world.txt</a>                                    02-Jan-2024 03:04      11
+<a href=".b.txt.ftpsync-partial">.b.txt.ftpsync-partial</a>                       02-Jan-2024 03:04       3
+</pre><hr></body>
+</html>`
+
+const apacheMovies = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
+<html>
+ <head>
+  <title>Index of /pub/movies</title>
+ </head>
+ <body>
+<h1>Index of /pub/movies</h1>
+  <table>
+   <tr><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th><th><a href="?C=S;O=A">Size</a></th></tr>
+   <tr><th colspan="5"><hr></th></tr>
+<tr><td><a href="/pub/">Parent Directory</a></td><td>&nbsp;</td><td align="right">  - </td></tr>
+<tr><td><a href="a.mkv">a.mkv</a></td><td align="right">2024-01-02 03:04  </td><td align="right">1.2K</td></tr>
+<tr><td><a href="json/">json/</a></td><td align="right">2024-01-02 03:04  </td><td align="right">  - </td></tr>
+<tr><td><a href="http://example.com/elsewhere.mkv">elsewhere.mkv</a></td><td align="right">2024-01-02 03:04  </td><td align="right">5</td></tr>
+   <tr><th colspan="5"><hr></th></tr>
+</table>
+</body></html>`
+
+const nginxJSON = `[
+{ "name":"sub", "type":"directory", "mtime":"Tue, 02 Jan 2024 03:04:05 GMT" },
+{ "name":"c.mkv", "type":"file", "mtime":"Tue, 02 Jan 2024 03:04:05 GMT", "size":2 }
+]`
+
+var modTime = time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+
+type fakeServer struct {
+	lock  sync.Mutex
+	heads []string
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if username, password, _ := r.BasicAuth(); username != "ftpsync" || password != "hunter2" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == "HEAD" {
+		s.lock.Lock()
+		s.heads = append(s.heads, r.URL.Path)
+		s.lock.Unlock()
+	}
+
+	switch r.URL.Path {
+	case "/pub/":
+		_, _ = w.Write([]byte(nginxRoot))
+	case "/pub/movies/":
+		_, _ = w.Write([]byte(apacheMovies))
+	case "/pub/movies/json/":
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(nginxJSON))
+	case "/pub/movies/json/sub/":
+		_, _ = w.Write([]byte("<html><body></body></html>"))
+	case "/pub/movies/a.mkv":
+		http.ServeContent(w, r, "a.mkv", modTime.Add(time.Second), strings.NewReader(strings.Repeat("a", 1234)))
+	case "/pub/hello world.txt":
+		http.ServeContent(w, r, "hello world.txt", modTime, strings.NewReader("hello world"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newIndex(t *testing.T) (*Index, *fakeServer) {
+	fake := &fakeServer{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL + "/pub")
+	require.NoError(t, err)
+	u.User = url.UserPassword("ftpsync", "hunter2")
+
+	index, err := New(u, nil, logrus.New())
+	require.NoError(t, err)
+
+	return index, fake
+}
+
+func TestGetAllFiles(t *testing.T) {
+	index, fake := newIndex(t)
+	defer index.Close()
+
+	files, err := index.GetAllFiles("/")
+	require.NoError(t, err)
+
+	expected := map[string]lib.FileInfo{
+		"/hello world.txt":   {Size: 11, ModTime: modTime},
+		"/movies/a.mkv":      {Size: 1234, ModTime: modTime.Add(time.Second)},
+		"/movies/json/c.mkv": {Size: 2, ModTime: modTime.Add(5 * time.Second)},
+	}
+	assert.Equal(t, len(expected), files.Len())
+	for path, info := range expected {
+		actual, ok := files.GetInfo(path)
+		require.True(t, ok, path)
+		assert.True(t, info.ModTime.Equal(actual.ModTime), path)
+		assert.Equal(t, info.Size, actual.Size, path)
+	}
+
+	// only the file without an exact size needed a HEAD
+	assert.Equal(t, []string{"/pub/movies/a.mkv"}, fake.heads)
+}
+
+func TestRead(t *testing.T) {
+	index, _ := newIndex(t)
+
+	for offset, expected := range map[int64]string{0: "hello world", 6: "world"} {
+		fp, err := index.Read("/hello world.txt", offset)
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(fp)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+		require.NoError(t, fp.Close())
+	}
+
+	_, err := index.Read("/missing.txt", 0)
+	assert.Error(t, err)
+}
+
+func TestParseDetails(t *testing.T) {
+	testCases := map[string]struct {
+		details []string
+		size    int64
+		modTime time.Time
+	}{
+		"nginx":          {strings.Fields("02-Jan-2024 03:04 11"), 11, modTime},
+		"nginx inexact":  {strings.Fields("02-Jan-2024 03:04 1K"), -1, modTime},
+		"apache":         {strings.Fields("2024-01-02 03:04 -"), -1, modTime},
+		"lighttpd":       {strings.Fields("2024-Jan-02 03:04:00 11"), 11, modTime},
+		"nothing useful": {strings.Fields("a description"), -1, time.Time{}},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			size, modTime := parseDetails(testCase.details)
+			assert.Equal(t, testCase.size, size)
+			assert.Equal(t, testCase.modTime, modTime)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBurst caps how much can be read at once, so concurrent downloads take
+// turns instead of one of them waiting for a whole second's worth of bytes.
+const maxBurst = 32 * 1024
+
+// BandwidthLimiter caps the combined speed of every reader it wraps.
+type BandwidthLimiter struct {
+	limiter *rate.Limiter
+	burst   int
+
+	// active reports whether the limit applies at the given time; nil means
+	// it always does.
+	active func(time.Time) bool
+	now    func() time.Time
+}
+
+func NewBandwidthLimiter(bytesPerSecond int64, active func(time.Time) bool) *BandwidthLimiter {
+	burst := maxBurst
+	if bytesPerSecond < maxBurst {
+		burst = max(int(bytesPerSecond), 1)
+	}
+
+	return &BandwidthLimiter{
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), burst),
+		burst:   burst,
+		active:  active,
+		now:     time.Now,
+	}
+}
+
+// Reader wraps fp so reading from it counts against the limit.
+func (b *BandwidthLimiter) Reader(fp io.ReadCloser) io.ReadCloser {
+	return &limitedReader{ReadCloser: fp, limiter: b}
+}
+
+func (b *BandwidthLimiter) isActive() bool {
+	return b.active == nil || b.active(b.now())
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	limiter *BandwidthLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if !r.limiter.isActive() {
+		return r.ReadCloser.Read(p)
+	}
+
+	if len(p) > r.limiter.burst {
+		p = p[:r.limiter.burst]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.limiter.WaitN(context.Background(), n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
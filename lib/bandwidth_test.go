@@ -0,0 +1,53 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, fp io.ReadCloser) {
+	_, err := io.Copy(io.Discard, fp)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+}
+
+func TestBandwidthLimiterIsShared(t *testing.T) {
+	// the first 1KB is free, so two readers of 1KB each take about 1s
+	limiter := NewBandwidthLimiter(1024, nil)
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readAll(t, limiter.Reader(io.NopCloser(bytes.NewReader(make([]byte, 1024)))))
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	assert.Greater(t, elapsed, 900*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestBandwidthLimiterSchedule(t *testing.T) {
+	isActive := false
+	limiter := NewBandwidthLimiter(1024, func(time.Time) bool { return isActive })
+
+	start := time.Now()
+	readAll(t, limiter.Reader(io.NopCloser(bytes.NewReader(make([]byte, 10*1024)))))
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	isActive = true
+	start = time.Now()
+	readAll(t, limiter.Reader(io.NopCloser(bytes.NewReader(make([]byte, 2*1024)))))
+	assert.Greater(t, time.Since(start), 900*time.Millisecond)
+}
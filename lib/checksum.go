@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SHA256 = "sha256"
+	SHA1   = "sha1"
+	MD5    = "md5"
+)
+
+// ChecksumAlgorithms lists the supported algorithms, strongest first.
+var ChecksumAlgorithms = []string{SHA256, SHA1, MD5}
+
+var ErrChecksumUnsupported = errors.New("checksums are not supported")
+
+type Checksum struct {
+	Algorithm string
+	Value     string
+}
+
+func (c Checksum) IsEmpty() bool {
+	return c.Value == ""
+}
+
+func (c Checksum) String() string {
+	return fmt.Sprintf("%s:%s", c.Algorithm, c.Value)
+}
+
+// Checksummer is implemented by sources and destinations that can hash a
+// file without it being downloaded first.
+type Checksummer interface {
+	// Checksum hashes path with the first of algorithms it supports, or
+	// returns ErrChecksumUnsupported if it supports none of them.
+	Checksum(path string, algorithms ...string) (Checksum, error)
+}
+
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA1:
+		return sha1.New(), nil
+	case MD5:
+		return md5.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm: %s", algorithm)
+	}
+}
+
+// HashReader hashes everything left in fp.
+func HashReader(fp io.Reader, algorithm string) (Checksum, error) {
+	h, err := NewHash(algorithm)
+	if err != nil {
+		return Checksum{}, err
+	}
+
+	if _, err = io.Copy(h, fp); err != nil {
+		return Checksum{}, errors.Wrap(err, "failed to hash file")
+	}
+
+	return Checksum{algorithm, hex.EncodeToString(h.Sum(nil))}, nil
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const envPrefix = "FTPSYNC_"
+
 type lookupName func(name string) (string, error)
 
 var allNumbers = regexp.MustCompile(`^\d+$`)
@@ -83,8 +85,24 @@ func parseGroup(groupId string) (GroupID, error) {
 }
 
 func ReadConfig() (Config, error) {
+	return parseConfig(nil)
+}
+
+// DefaultConfig is the Config of an empty environment.
+func DefaultConfig() Config {
+	cfg, err := parseConfig(map[string]string{})
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+// parseConfig reads a Config out of environment, which holds FTPSYNC_*
+// variables. A nil environment reads the process's environment instead.
+func parseConfig(environment map[string]string) (Config, error) {
 	return env.ParseAsWithOptions[Config](env.Options{
-		Prefix: "FTPSYNC_",
+		Prefix:      envPrefix,
+		Environment: environment,
 		FuncMap: map[reflect.Type]env.ParserFunc{
 			reflect.TypeOf(logrus.Level(0)): func(v string) (interface{}, error) {
 				return logrus.ParseLevel(v)
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const yamlConfig = `
+defaults:
+  database: /data/ftpsync.db
+  dir_mode: 0750
+  log-level: info
+jobs:
+  tv:
+    source: ftp://example.com/tv
+    destination: /media/tv
+    root_dir: /tv
+    repeat: 15m
+  movies:
+    source: sftp://example.com/movies
+    destination: /media/movies
+    root_dir: /movies
+    concurrency: 3
+`
+
+const tomlConfig = `
+[defaults]
+database = "/data/ftpsync.db"
+dir_mode = "0750"
+
+[jobs.movies]
+source = "sftp://example.com/movies"
+destination = "/media/movies"
+root_dir = "/movies"
+concurrency = 3
+`
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestReadJobsFromYAML(t *testing.T) {
+	path := writeConfigFile(t, "ftpsync.yaml", yamlConfig)
+
+	jobs, err := ReadJobs(path, nil)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+
+	movies, tv := jobs[0], jobs[1]
+
+	assert.Equal(t, "movies", movies.Name)
+	assert.Equal(t, "sftp://example.com/movies", movies.Source)
+	assert.Equal(t, "/data/ftpsync.db", movies.Database)
+	assert.Equal(t, os.FileMode(0o750), movies.DirMode)
+	assert.Equal(t, os.FileMode(0o666), movies.FileMode)
+	assert.Equal(t, 3, movies.Concurrency)
+	assert.Equal(t, time.Duration(0), movies.Repeat)
+
+	assert.Equal(t, "tv", tv.Name)
+	assert.Equal(t, "/tv", tv.RootDir)
+	assert.Equal(t, 15*time.Minute, tv.Repeat)
+	assert.Equal(t, 1, tv.Concurrency)
+}
+
+func TestReadJobsFromTOML(t *testing.T) {
+	path := writeConfigFile(t, "ftpsync.toml", tomlConfig)
+
+	jobs, err := ReadJobs(path, nil)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	assert.Equal(t, "movies", jobs[0].Name)
+	assert.Equal(t, os.FileMode(0o750), jobs[0].DirMode)
+	assert.Equal(t, 3, jobs[0].Concurrency)
+}
+
+func TestReadJobsOverrides(t *testing.T) {
+	path := writeConfigFile(t, "ftpsync.yaml", yamlConfig)
+
+	t.Setenv("FTPSYNC_DATABASE", "/env/ftpsync.db")
+	t.Setenv("FTPSYNC_CONCURRENCY", "5")
+
+	jobs, err := ReadJobs(path, map[string]string{"CONCURRENCY": "7"})
+	require.NoError(t, err)
+
+	for _, job := range jobs {
+		assert.Equal(t, "/env/ftpsync.db", job.Database)
+		assert.Equal(t, 7, job.Concurrency)
+	}
+}
+
+func TestReadJobsWithoutFile(t *testing.T) {
+	t.Setenv("FTPSYNC_SOURCE", "ftp://example.com")
+
+	jobs, err := ReadJobs("", map[string]string{"ROOT_DIR": "/flag"})
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	assert.Equal(t, DefaultJobName, jobs[0].Name)
+	assert.Equal(t, "ftp://example.com", jobs[0].Source)
+	assert.Equal(t, "/flag", jobs[0].RootDir)
+}
+
+func TestReadJobsUnknownSetting(t *testing.T) {
+	path := writeConfigFile(t, "ftpsync.yaml", "jobs:\n  a:\n    sauce: ftp://example.com\n")
+
+	_, err := ReadJobs(path, nil)
+	assert.EqualError(t, err, `job a: unknown setting "sauce"`)
+}
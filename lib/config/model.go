@@ -1,24 +1,123 @@
 package config
 
 import (
+	"net/url"
 	"os"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 type UserID int
 type GroupID int
 
+const (
+	DirectionDownload = "download"
+	DirectionUpload   = "upload"
+	DirectionBoth     = "both"
+
+	LinksSkip     = "skip"
+	LinksFollow   = "follow"
+	LinksRecreate = "recreate"
+)
+
 type Config struct {
-	Database    string `env:"DATABASE,required" envDefault:"ftpsync.db"`
-	Source      string `env:"SOURCE,required"`
-	Precheck    string `env:"PRECHECK"`
-	Destination string `env:"DESTINATION,required"`
+	Database string `env:"DATABASE" envDefault:"ftpsync.db"`
+	Source   string `env:"SOURCE"`
+
+	// Destination is a local directory, or a file://, ftp://, sftp://,
+	// filebrowser:// or s3:// url to mirror Source to another server.
+	// Ownership, modes, trash and extraction only apply to local
+	// directories.
+	Destination string `env:"DESTINATION"`
+
+	// Precheck is a deluge, qbittorrent or transmission url. Its list of
+	// downloads is cached for PrecheckTTL, or less when a file isn't ready.
+	Precheck    string        `env:"PRECHECK"`
+	PrecheckTTL time.Duration `env:"PRECHECK_TTL" envDefault:"1m"`
+
+	// Direction is download to mirror Source into Destination, upload to
+	// mirror Destination up to Source, or both to copy changes both ways.
+	// Source is always the server.
+	Direction string `env:"DIRECTION" envDefault:"download"`
+
+	Repeat      time.Duration `env:"REPEAT"`
+	Concurrency int           `env:"CONCURRENCY" envDefault:"1"`
+
+	DryRun     bool   `env:"DRY_RUN"`
+	PlanFormat string `env:"PLAN_FORMAT" envDefault:"text"`
+
+	VerifyChecksums bool `env:"VERIFY_CHECKSUMS"`
+	VerifyLocal     bool `env:"VERIFY_LOCAL"`
+
+	PreserveModTime bool `env:"PRESERVE_MTIME"`
+
+	// MaxRate is shared by every download in every job with the same rate
+	// and schedule, and only applies during MaxRateSchedule, if it's set.
+	MaxRate         ByteRate `env:"MAX_RATE"`
+	MaxRateSchedule Schedule `env:"MAX_RATE_SCHEDULE"`
+
+	// Retention is mirror, keep or keep-for=30d. Only one-way syncs keep
+	// files.
+	Retention Retention `env:"RETENTION" envDefault:"mirror"`
+
+	// Include and Exclude are comma separated globs, which support ** and
+	// match the path under RootDir, or just the file name if they have no
+	// slashes. The filters apply to both sides of the sync.
+	Include       []string `env:"INCLUDE"`
+	Exclude       []string `env:"EXCLUDE"`
+	IncludeRegex  string   `env:"INCLUDE_REGEX"`
+	ExcludeRegex  string   `env:"EXCLUDE_REGEX"`
+	MinSize       ByteSize `env:"MIN_SIZE"`
+	MaxSize       ByteSize `env:"MAX_SIZE"`
+	ExcludeHidden bool     `env:"EXCLUDE_HIDDEN"`
+
+	// Links is what to do with symlinks on either side: skip them, follow
+	// them, or recreate them, when they point inside RootDir. Servers that
+	// don't say where links point, like filebrowser, can't recreate them,
+	// and only follow links to files.
+	Links string `env:"LINKS" envDefault:"skip"`
+
+	// PathRules rename files on the way to the destination, separated by
+	// semicolons, like "strip=1;*.mkv=video/". See lib.PathMapper. Changing
+	// them downloads every renamed file again.
+	PathRules []string `env:"PATH_RULES" envSeparator:";"`
 
-	Repeat time.Duration `env:"REPEAT"`
+	// Extract unpacks zip, tar, tar.gz and rar downloads next to
+	// themselves, once. Only downloads to a local folder are extracted.
+	Extract bool `env:"EXTRACT"`
 
-	RootDir string `env:"ROOT_DIR,required"`
+	// MaxDeletes and MaxDeletePercent stop a sync before it changes anything
+	// if it would delete more local files than either allows, unless Force
+	// is set. Zero turns a limit off.
+	MaxDeletes       int     `env:"MAX_DELETES"`
+	MaxDeletePercent float64 `env:"MAX_DELETE_PERCENT"`
+	Force            bool    `env:"FORCE"`
+
+	// TrashDir is where deleted local files are moved to, instead of being
+	// removed, and must be on the same filesystem as Destination. Anything
+	// in it older than TrashRetention is purged, unless that is zero.
+	TrashDir       string `env:"TRASH_DIR"`
+	TrashRetention Period `env:"TRASH_RETENTION"`
+
+	// FileHookCommand and FileHookURL run after every download, and
+	// SyncHookCommand and SyncHookURL after every sync. Commands get the
+	// details as FTPSYNC_ environment variables, URLs get them POSTed as
+	// JSON. Hooks that fail or take longer than HookTimeout are logged.
+	// File hooks run one at a time next to the downloads, which only wait
+	// for them once 100 files are queued.
+	FileHookCommand string        `env:"FILE_HOOK_COMMAND"`
+	FileHookURL     string        `env:"FILE_HOOK_URL"`
+	SyncHookCommand string        `env:"SYNC_HOOK_COMMAND"`
+	SyncHookURL     string        `env:"SYNC_HOOK_URL"`
+	HookTimeout     time.Duration `env:"HOOK_TIMEOUT" envDefault:"1m"`
+
+	// MetricsAddr is where to serve prometheus metrics, like :9090. Jobs
+	// with the same address share a listener.
+	MetricsAddr string `env:"METRICS_ADDR"`
+
+	RootDir string `env:"ROOT_DIR"`
 
 	DirMode  os.FileMode `env:"DIR_MODE" envDefault:"0777"`
 	FileMode os.FileMode `env:"FILE_MODE" envDefault:"0666"`
@@ -32,3 +131,65 @@ type Config struct {
 	FileUserID  UserID  `env:"FILE_USER_ID"`
 	FileGroupID GroupID `env:"FILE_GROUP_ID"`
 }
+
+func (c Config) IsUpload() bool {
+	return c.Direction == DirectionUpload
+}
+
+func (c Config) IsTwoWay() bool {
+	return c.Direction == DirectionBoth
+}
+
+// LocalDestination returns the directory Destination points to, or false
+// if it's on another server.
+func (c Config) LocalDestination() (string, bool) {
+	u, err := url.Parse(c.Destination)
+	if err != nil {
+		return c.Destination, true
+	}
+
+	switch {
+	case u.Scheme == "file":
+		return u.Path, true
+	case len(u.Scheme) <= 1:
+		// no scheme, or a windows drive letter
+		return c.Destination, true
+	default:
+		return "", false
+	}
+}
+
+// Validate checks for settings that are required but can come from either
+// the environment or the command line.
+func (c Config) Validate() error {
+	_, isLocal := c.LocalDestination()
+
+	switch {
+	case c.Database == "":
+		return errors.New("must define a database")
+	case c.Source == "":
+		return errors.New("must define a source")
+	case c.Destination == "":
+		return errors.New("must define a destination")
+	case c.RootDir == "":
+		return errors.New("must define a root dir")
+	case c.Concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case c.MaxDeletes < 0 || c.MaxDeletePercent < 0 || c.MaxDeletePercent > 100:
+		return errors.New("deletion limits must be positive, and percentages at most 100")
+	case c.Direction != DirectionDownload && c.Direction != DirectionUpload && c.Direction != DirectionBoth:
+		return errors.Errorf("direction must be %s, %s or %s", DirectionDownload, DirectionUpload, DirectionBoth)
+	case c.Links != LinksSkip && c.Links != LinksFollow && c.Links != LinksRecreate:
+		return errors.Errorf("links must be %s, %s or %s", LinksSkip, LinksFollow, LinksRecreate)
+	case c.Links == LinksRecreate && c.Direction != DirectionDownload:
+		return errors.New("links can only be recreated by downloads")
+	case !isLocal && c.Direction != DirectionDownload:
+		return errors.New("only downloads can write to a remote destination")
+	case len(c.PathRules) > 0 && c.IsTwoWay():
+		return errors.New("two-way sync can't rename files")
+	case c.Retention.Keep && c.IsTwoWay():
+		return errors.New("two-way sync can't keep removed files")
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var rateUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"G", 1 << 30},
+	{"MB", 1 << 20},
+	{"M", 1 << 20},
+	{"KB", 1 << 10},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+var rateNames = []string{"B", "KB", "MB", "GB"}
+
+// ByteRate is a transfer rate in bytes per second, written like 5MB/s. Zero
+// means unlimited.
+type ByteRate int64
+
+func (r *ByteRate) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(strings.ToUpper(string(text)))
+	value = strings.TrimSuffix(value, "/S")
+
+	if value == "UNLIMITED" {
+		*r = 0
+		return nil
+	}
+
+	amount, ok := parseBytes(value)
+	if !ok {
+		return fmt.Errorf("invalid rate: %q", string(text))
+	}
+
+	*r = ByteRate(amount)
+	return nil
+}
+
+func (r ByteRate) String() string {
+	if r <= 0 {
+		return "unlimited"
+	}
+
+	return fmtBytes(int64(r)) + "/s"
+}
+
+// ByteSize is a number of bytes, written like 700MB.
+type ByteSize int64
+
+func (s *ByteSize) UnmarshalText(text []byte) error {
+	amount, ok := parseBytes(strings.TrimSpace(strings.ToUpper(string(text))))
+	if !ok {
+		return fmt.Errorf("invalid size: %q", string(text))
+	}
+
+	*s = ByteSize(amount)
+	return nil
+}
+
+func (s ByteSize) String() string {
+	return fmtBytes(int64(s))
+}
+
+// parseBytes parses an upper case amount like 5MB, or an empty string as
+// zero.
+func parseBytes(value string) (int64, bool) {
+	if value == "" {
+		return 0, true
+	}
+
+	multiplier := int64(1)
+	for _, unit := range rateUnits {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value, multiplier = number, unit.multiplier
+			break
+		}
+	}
+
+	amount, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || amount < 0 {
+		return 0, false
+	}
+
+	return int64(amount * float64(multiplier)), true
+}
+
+func fmtBytes(bytes int64) string {
+	amount := float64(bytes)
+	idx := 0
+	for amount >= 1024 && idx < len(rateNames)-1 {
+		idx++
+		amount /= 1024
+	}
+
+	return strconv.FormatFloat(amount, 'f', -1, 64) + rateNames[idx]
+}
+
+// Schedule is a list of daily time windows, written like
+// "08:00-12:00,13:00-23:00". A window that ends before it starts runs past
+// midnight.
+type Schedule struct {
+	windows []window
+}
+
+type window struct {
+	start, end time.Duration
+}
+
+func (s *Schedule) UnmarshalText(text []byte) error {
+	s.windows = nil
+
+	for _, part := range strings.Split(string(text), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		start, end, ok := strings.Cut(part, "-")
+		if !ok {
+			return fmt.Errorf("invalid time window: %q", part)
+		}
+
+		var (
+			w   window
+			err error
+		)
+		if w.start, err = parseTimeOfDay(start); err != nil {
+			return err
+		}
+		if w.end, err = parseTimeOfDay(end); err != nil {
+			return err
+		}
+
+		s.windows = append(s.windows, w)
+	}
+
+	return nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day: %q", value)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (s Schedule) IsEmpty() bool {
+	return len(s.windows) == 0
+}
+
+// Contains reports whether t falls within any of the windows, in t's time
+// zone.
+func (s Schedule) Contains(t time.Time) bool {
+	hour, minute, second := t.Clock()
+	offset := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+
+	for _, w := range s.windows {
+		if w.start <= w.end {
+			if offset >= w.start && offset < w.end {
+				return true
+			}
+		} else if offset >= w.start || offset < w.end {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Schedule) String() string {
+	parts := make([]string, 0, len(s.windows))
+	for _, w := range s.windows {
+		parts = append(parts, fmtTimeOfDay(w.start)+"-"+fmtTimeOfDay(w.end))
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func fmtTimeOfDay(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(offset.Hours()), int(offset.Minutes())%60)
+}
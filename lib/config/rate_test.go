@@ -0,0 +1,76 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteRate(t *testing.T) {
+	testCases := map[string]ByteRate{
+		"":          0,
+		"unlimited": 0,
+		"100":       100,
+		"100B/s":    100,
+		"5MB/s":     5 << 20,
+		"5mb":       5 << 20,
+		"1.5K/s":    1536,
+		"2G":        2 << 30,
+	}
+
+	for value, expected := range testCases {
+		t.Run(value, func(t *testing.T) {
+			var rate ByteRate
+			require.NoError(t, rate.UnmarshalText([]byte(value)))
+			assert.Equal(t, expected, rate)
+		})
+	}
+
+	var rate ByteRate
+	assert.Error(t, rate.UnmarshalText([]byte("fast")))
+	assert.Error(t, rate.UnmarshalText([]byte("-5MB/s")))
+
+	assert.Equal(t, "5MB/s", ByteRate(5<<20).String())
+	assert.Equal(t, "1.5KB/s", ByteRate(1536).String())
+	assert.Equal(t, "unlimited", ByteRate(0).String())
+}
+
+func TestByteSize(t *testing.T) {
+	var size ByteSize
+	require.NoError(t, size.UnmarshalText([]byte("700mb")))
+	assert.Equal(t, ByteSize(700<<20), size)
+	assert.Equal(t, "700MB", size.String())
+
+	require.NoError(t, size.UnmarshalText(nil))
+	assert.Equal(t, ByteSize(0), size)
+	assert.Equal(t, "0B", size.String())
+
+	assert.Error(t, size.UnmarshalText([]byte("big")))
+}
+
+func TestSchedule(t *testing.T) {
+	var schedule Schedule
+	require.NoError(t, schedule.UnmarshalText([]byte("08:00-12:00, 22:30-02:00")))
+	assert.Equal(t, "08:00-12:00,22:30-02:00", schedule.String())
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2023, 5, 31, hour, minute, 0, 0, time.UTC)
+	}
+
+	assert.False(t, schedule.Contains(at(7, 59)))
+	assert.True(t, schedule.Contains(at(8, 0)))
+	assert.True(t, schedule.Contains(at(11, 59)))
+	assert.False(t, schedule.Contains(at(12, 0)))
+	assert.False(t, schedule.Contains(at(22, 29)))
+	assert.True(t, schedule.Contains(at(23, 0)))
+	assert.True(t, schedule.Contains(at(1, 0)))
+	assert.False(t, schedule.Contains(at(2, 0)))
+
+	require.NoError(t, schedule.UnmarshalText(nil))
+	assert.True(t, schedule.IsEmpty())
+
+	assert.Error(t, schedule.UnmarshalText([]byte("08:00")))
+	assert.Error(t, schedule.UnmarshalText([]byte("8am-5pm")))
+}
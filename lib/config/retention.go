@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Retention is what happens to local files once they're removed from the
+// source: mirror deletes them, keep never does, and keep-for=30d deletes
+// them 30 days later.
+type Retention struct {
+	Keep bool
+	// For is how long removed files are kept, or zero for forever.
+	For time.Duration
+}
+
+func (r *Retention) UnmarshalText(text []byte) error {
+	value := strings.ToLower(strings.TrimSpace(string(text)))
+
+	switch value {
+	case "", "mirror":
+		*r = Retention{}
+		return nil
+	case "keep":
+		*r = Retention{Keep: true}
+		return nil
+	}
+
+	period, ok := strings.CutPrefix(value, "keep-for=")
+	if !ok {
+		return fmt.Errorf("invalid retention: %q", string(text))
+	}
+
+	keepFor, err := parsePeriod(period)
+	if err != nil || keepFor <= 0 {
+		return fmt.Errorf("invalid retention period: %q", period)
+	}
+
+	*r = Retention{Keep: true, For: keepFor}
+	return nil
+}
+
+// Period is a duration that can also be written as a whole number of days,
+// like 30d.
+type Period time.Duration
+
+func (p *Period) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if value == "" {
+		*p = 0
+		return nil
+	}
+
+	period, err := parsePeriod(value)
+	if err != nil || period < 0 {
+		return fmt.Errorf("invalid period: %q", value)
+	}
+
+	*p = Period(period)
+	return nil
+}
+
+func (p Period) String() string {
+	return fmtPeriod(time.Duration(p))
+}
+
+// parsePeriod parses a duration, which can also be a whole number of days,
+// like 30d.
+func parsePeriod(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(count) * day, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+func (r Retention) String() string {
+	switch {
+	case !r.Keep:
+		return "mirror"
+	case r.For == 0:
+		return "keep"
+	default:
+		return "keep-for=" + fmtPeriod(r.For)
+	}
+}
+
+func fmtPeriod(period time.Duration) string {
+	if period != 0 && period%day == 0 {
+		return fmt.Sprintf("%dd", period/day)
+	}
+
+	return period.String()
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention(t *testing.T) {
+	testCases := map[string]Retention{
+		"":             {},
+		"mirror":       {},
+		"keep":         {Keep: true},
+		"Keep-For=30d": {Keep: true, For: 30 * day},
+		"keep-for=12h": {Keep: true, For: 12 * time.Hour},
+	}
+
+	for value, expected := range testCases {
+		t.Run(value, func(t *testing.T) {
+			var retention Retention
+			require.NoError(t, retention.UnmarshalText([]byte(value)))
+			assert.Equal(t, expected, retention)
+		})
+	}
+
+	var retention Retention
+	assert.Error(t, retention.UnmarshalText([]byte("archive")))
+	assert.Error(t, retention.UnmarshalText([]byte("keep-for=0d")))
+	assert.Error(t, retention.UnmarshalText([]byte("keep-for=1d6h")))
+
+	assert.Equal(t, "mirror", Retention{}.String())
+	assert.Equal(t, "keep", Retention{Keep: true}.String())
+	assert.Equal(t, "keep-for=30d", Retention{Keep: true, For: 30 * day}.String())
+	assert.Equal(t, "keep-for=12h0m0s", Retention{Keep: true, For: 12 * time.Hour}.String())
+}
+
+func TestPeriod(t *testing.T) {
+	var period Period
+	require.NoError(t, period.UnmarshalText([]byte("7d")))
+	assert.Equal(t, Period(7*day), period)
+	assert.Equal(t, "7d", period.String())
+
+	require.NoError(t, period.UnmarshalText([]byte("90m")))
+	assert.Equal(t, Period(90*time.Minute), period)
+	assert.Equal(t, "1h30m0s", period.String())
+
+	require.NoError(t, period.UnmarshalText(nil))
+	assert.Equal(t, Period(0), period)
+
+	assert.Error(t, period.UnmarshalText([]byte("soon")))
+	assert.Error(t, period.UnmarshalText([]byte("-1d")))
+}
@@ -0,0 +1,16 @@
+package lib
+
+import "io"
+
+// CountingReader counts the bytes read through it, for writers that don't
+// report how much they wrote.
+type CountingReader struct {
+	io.Reader
+	Count int64
+}
+
+func (r *CountingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.Count += int64(n)
+	return n, err
+}
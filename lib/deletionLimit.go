@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooManyDeletes is returned by Execute when a plan would delete more
+// files than the deletion limit allows, which usually means the source
+// returned a bad listing.
+var ErrTooManyDeletes = errors.New("too many files would be deleted")
+
+// DeletesFile reports whether the action removes a file, rather than just
+// its record. Downloads that replace the local copy remove it first, so a
+// listing with the wrong sizes counts too.
+func (a PlannedAction) DeletesFile() bool {
+	if a.ReplacesLocal {
+		return true
+	}
+
+	switch a.Action.Name {
+	case "delete":
+		return a.State.HasLocal
+	case "delete-remote":
+		return true
+	default:
+		return false
+	}
+}
+
+// checkDeletionLimit returns ErrTooManyDeletes if plan deletes more than
+// maxDeletes files, or more than maxDeletePercent of the local files.
+func (p *Processor) checkDeletionLimit(plan *Plan) error {
+	deletes, err := p.deletionLimitError(plan)
+	if err != nil {
+		p.metrics.DeletesBlocked(deletes)
+	}
+
+	return err
+}
+
+// deletionLimitError is checkDeletionLimit without counting a blocked sync,
+// and also returns how many files plan deletes.
+func (p *Processor) deletionLimitError(plan *Plan) (int, error) {
+	if p.maxDeletes <= 0 && p.maxDeletePercent <= 0 {
+		return 0, nil
+	}
+
+	var deletes int
+	for _, action := range plan.Actions {
+		if action.DeletesFile() {
+			deletes++
+		}
+	}
+	if deletes == 0 {
+		return 0, nil
+	}
+
+	percent := 100.0
+	if plan.LocalCount > 0 {
+		percent = float64(deletes) * 100 / float64(plan.LocalCount)
+	}
+
+	if (p.maxDeletes > 0 && deletes > p.maxDeletes) || (p.maxDeletePercent > 0 && percent > p.maxDeletePercent) {
+		return deletes, fmt.Errorf("%w: %d of %d local files (%.1f%%), force the sync to delete them anyway",
+			ErrTooManyDeletes, deletes, plan.LocalCount, percent)
+	}
+
+	return deletes, nil
+}
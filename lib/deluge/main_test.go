@@ -1,13 +1,21 @@
 package deluge
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golift.io/deluge"
+
+	"github.com/djeebus/ftpsync/lib/torrent"
 )
 
 func getRequiredEnvVar(t *testing.T, key string) string {
@@ -26,10 +34,52 @@ func TestCreation(t *testing.T) {
 	delugeUrl, err := url.Parse(delugeUrlText)
 	require.NoError(t, err)
 
-	precheck, err := New(logrus.New(), delugeUrl, "/testing/")
+	client, err := New(logrus.New(), delugeUrl)
+	require.NoError(t, err)
+
+	precheck, err := torrent.New(logrus.New(), client, "/testing/", time.Minute)
 	require.NoError(t, err)
 
 	isGood, err := precheck.IsFileReady(xferPath)
 	require.NoError(t, err)
 	assert.Equal(t, isXferComplete, isGood)
 }
+
+func TestFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		var result string
+		switch req.Method {
+		case deluge.AuthLogin:
+			result = `true`
+		case deluge.GeHosts:
+			result = `[["host", "127.0.0.1", 58846, "Connected"]]`
+		case deluge.HostStatus:
+			result = `["host", "Connected", "2.1.1"]`
+		case deluge.GetAllTorrents:
+			result = `{"abc": {
+				"files": [{"index": 0, "path": "a/done.mkv"}, {"index": 1, "path": "a/partial.mkv"}],
+				"file_progress": [1, 0.5]
+			}}`
+		default:
+			t.Errorf("unexpected method %s", req.Method)
+		}
+
+		w.Write([]byte(`{"id": 1, "result": ` + result + `, "error": null}`))
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(strings.Replace(server.URL, "http://", "deluge://:secret@", 1))
+	require.NoError(t, err)
+
+	client, err := New(logrus.New(), u)
+	require.NoError(t, err)
+
+	files, err := client.Files()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a/done.mkv": true, "a/partial.mkv": false}, files)
+}
@@ -0,0 +1,204 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nwaples/rardecode/v2"
+	"github.com/pkg/errors"
+)
+
+// later volumes of a multi-part rar, which are read along with the first
+var laterRarVolume = regexp.MustCompile(`\.part0*([2-9]|[1-9]\d+)\.rar$`)
+
+// IsArchive reports whether name is an archive that can be extracted. Only
+// the first volume of a multi-part rar counts.
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"),
+		strings.HasSuffix(name, ".tar"),
+		strings.HasSuffix(name, ".tar.gz"),
+		strings.HasSuffix(name, ".tgz"):
+		return true
+	case strings.HasSuffix(name, ".rar"):
+		return !laterRarVolume.MatchString(name)
+	default:
+		return false
+	}
+}
+
+// entryFunc is called with every file in an archive.
+type entryFunc func(name string, r io.Reader) error
+
+// Extract unpacks the archive at archivePath into dir, and returns the
+// paths of the files it wrote, relative to dir. Files that already exist
+// are left alone, and left out of the result. If it fails, everything it
+// wrote is removed.
+func Extract(archivePath, dir string, dirMode, fileMode fs.FileMode) ([]string, error) {
+	var written []string
+
+	write := func(name string, r io.Reader) error {
+		name, ok := cleanName(name)
+		if !ok {
+			return fmt.Errorf("refusing to extract %q outside of %s", name, dir)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if _, err := os.Lstat(target); err == nil {
+			return nil
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), dirMode); err != nil {
+			return errors.Wrap(err, "failed to create directory")
+		}
+
+		fp, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, fileMode)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create %s", name)
+		}
+
+		if _, err = io.Copy(fp, r); err != nil {
+			fp.Close()
+			os.Remove(target)
+			return errors.Wrapf(err, "failed to extract %s", name)
+		}
+
+		if err = fp.Close(); err != nil {
+			return errors.Wrapf(err, "failed to close %s", name)
+		}
+
+		written = append(written, name)
+		return nil
+	}
+
+	var err error
+	lower := strings.ToLower(archivePath)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		err = walkZip(archivePath, write)
+	case strings.HasSuffix(lower, ".rar"):
+		err = walkRar(archivePath, write)
+	default:
+		err = walkTar(archivePath, write)
+	}
+
+	if err != nil {
+		for _, name := range written {
+			os.Remove(filepath.Join(dir, filepath.FromSlash(name)))
+		}
+		return nil, err
+	}
+
+	return written, nil
+}
+
+// cleanName returns name relative to the extraction directory, or false if
+// it would end up outside of it.
+func cleanName(name string) (string, bool) {
+	name = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	if path.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return name, false
+	}
+
+	return name, true
+}
+
+func walkZip(archivePath string, fn entryFunc) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open zip")
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return errors.Wrapf(err, "failed to open %s", file.Name)
+		}
+
+		err = fn(file.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkTar(archivePath string, fn entryFunc) error {
+	fp, err := os.Open(archivePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open tar")
+	}
+	defer fp.Close()
+
+	var r io.Reader = fp
+	if lower := strings.ToLower(archivePath); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(fp)
+		if err != nil {
+			return errors.Wrap(err, "failed to open gzip")
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read tar")
+		}
+
+		// links could point anywhere, so only regular files are extracted
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err = fn(header.Name, reader); err != nil {
+			return err
+		}
+	}
+}
+
+func walkRar(archivePath string, fn entryFunc) error {
+	reader, err := rardecode.OpenReader(archivePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open rar")
+	}
+	defer reader.Close()
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to read rar")
+		}
+
+		if header.IsDir || header.LinkType != 0 {
+			continue
+		}
+
+		if err = fn(header.Name, reader); err != nil {
+			return err
+		}
+	}
+}
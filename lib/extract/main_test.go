@@ -0,0 +1,127 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	fp, err := os.Create(path)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	writer := zip.NewWriter(fp)
+	for name, content := range files {
+		w, err := writer.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+}
+
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	fp, err := os.Create(path)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	gz := gzip.NewWriter(fp)
+	writer := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, writer.WriteHeader(&tar.Header{
+			Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg,
+		}))
+		_, err = writer.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.WriteHeader(&tar.Header{
+		Name: "link", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink,
+	}))
+	require.NoError(t, writer.Close())
+	require.NoError(t, gz.Close())
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestIsArchive(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"a.zip":          true,
+		"a.ZIP":          true,
+		"a.tar":          true,
+		"a.tar.gz":       true,
+		"a.tgz":          true,
+		"a.rar":          true,
+		"a.part1.rar":    true,
+		"a.part01.rar":   true,
+		"a.part2.rar":    false,
+		"a.part10.rar":   false,
+		"a.r00":          false,
+		"a.mkv":          false,
+		"a.gz":           false,
+		"zip/readme.txt": false,
+	} {
+		assert.Equal(t, expected, IsArchive(name), name)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "a.zip")
+	writeZip(t, archive, map[string]string{"a.mkv": "video", "subs/a.srt": "subs", "existing.txt": "new"})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("old"), 0o644))
+
+	files, err := Extract(archive, dir, 0o755, 0o644)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a.mkv", "subs/a.srt"}, files)
+
+	assert.Equal(t, "video", readFile(t, filepath.Join(dir, "a.mkv")))
+	assert.Equal(t, "subs", readFile(t, filepath.Join(dir, "subs", "a.srt")))
+	assert.Equal(t, "old", readFile(t, filepath.Join(dir, "existing.txt")))
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "a.tar.gz")
+	writeTarGz(t, archive, map[string]string{"a/b.txt": "b"})
+
+	files, err := Extract(archive, dir, 0o755, 0o644)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/b.txt"}, files)
+	assert.Equal(t, "b", readFile(t, filepath.Join(dir, "a", "b.txt")))
+
+	_, err = os.Lstat(filepath.Join(dir, "link"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExtractRefusesEscapes(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dir")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+
+	archive := filepath.Join(dir, "a.zip")
+	writeZip(t, archive, map[string]string{"a.txt": "a"})
+	files, err := Extract(archive, dir, 0o755, 0o644)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt"}, files)
+
+	archive = filepath.Join(dir, "b.zip")
+	writeZip(t, archive, map[string]string{"b.txt": "b", "../escaped.txt": "escaped"})
+	_, err = Extract(archive, dir, 0o755, 0o644)
+	require.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(root, "escaped.txt"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "b.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WithArchiveExtraction unpacks archives next to themselves once they have
+// been downloaded, when the destination is an Extractor. Every archive is
+// only extracted once, and the files it held are kept for as long as it is.
+func WithArchiveExtraction() Option {
+	return func(p *Processor) {
+		p.extract = true
+	}
+}
+
+// planExtracted keeps a local file that isn't on the source if it was
+// extracted from an archive that's being kept, and returns false otherwise.
+func (p *Processor) planExtracted(file string, extracted map[string]string, remoteFiles *SizeSet, dbFiles *Set) (PlannedAction, bool) {
+	archive, ok := extracted[file]
+	if !ok || !dbFiles.Has(archive) {
+		return PlannedAction{}, false
+	}
+
+	if !remoteFiles.Has(archive) && !p.keepRemoved {
+		return PlannedAction{}, false
+	}
+
+	return PlannedAction{
+		Path:   file,
+		State:  FileStatusKey{HasLocal: true},
+		Action: NamedAction{skipFile, "skip"},
+		Reason: fmt.Sprintf("extracted from %s", archive),
+	}, true
+}
+
+// extractArchives extracts every downloaded archive that hasn't been yet.
+// Multi-part archives need all of their parts, so this waits until every
+// download has finished.
+func (p *Processor) extractArchives() {
+	extractor, ok := p.local.(Extractor)
+	if !ok {
+		p.log.Debug("destination can't extract archives")
+		return
+	}
+
+	paths := p.remoteFiles.ToSet().ToList()
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		localPath := p.localPath(path)
+		if !extractor.IsArchive(localPath) {
+			continue
+		}
+
+		log := p.log.WithField("path", path)
+
+		if isRecorded, err := p.db.Exists(path); err != nil {
+			log.WithError(err).Error("failed to check database")
+			continue
+		} else if !isRecorded {
+			continue
+		}
+
+		if isExtracted, err := p.db.IsExtracted(path); err != nil {
+			log.WithError(err).Error("failed to check extraction")
+			continue
+		} else if isExtracted {
+			continue
+		}
+
+		if exists, err := p.local.Exists(localPath); err != nil || !exists {
+			continue
+		}
+
+		files, err := extractor.Extract(localPath)
+		if err != nil {
+			log.WithError(err).Error("failed to extract archive")
+			continue
+		}
+
+		if err = p.db.RecordExtraction(path, files); err != nil {
+			log.WithError(err).Error("failed to record extraction")
+			continue
+		}
+
+		log.WithField("count", len(files)).Info("extracted archive")
+	}
+}
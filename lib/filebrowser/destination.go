@@ -0,0 +1,181 @@
+package filebrowser
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+var (
+	_ lib.Destination = new(FileBrowser)
+	_ lib.Stater      = new(FileBrowser)
+)
+
+// GetPartialSize always returns 0, as uploads can't be resumed.
+func (f *FileBrowser) GetPartialSize(string) (int64, error) {
+	return 0, nil
+}
+
+func (f *FileBrowser) Exists(path string) (bool, error) {
+	if err := f.ensureLogin(); err != nil {
+		return false, err
+	}
+
+	response, err := f.request("GET", f.resourceURL(path))
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return false, nil
+	case response.StatusCode >= 400:
+		return false, fmt.Errorf("failed to check file: %d", response.StatusCode)
+	default:
+		return true, nil
+	}
+}
+
+func (f *FileBrowser) Stat(path string) (lib.FileInfo, error) {
+	if err := f.ensureLogin(); err != nil {
+		return lib.FileInfo{}, err
+	}
+
+	resource, err := f.getResource(path)
+	if err != nil {
+		return lib.FileInfo{}, err
+	}
+
+	return lib.FileInfo{Size: resource.Size, ModTime: resource.Modified}, nil
+}
+
+func (f *FileBrowser) Write(path string, offset int64, fp io.ReadCloser) (int64, error) {
+	if offset != 0 {
+		return 0, errors.New("filebrowser does not support resuming uploads")
+	}
+
+	if err := f.ensureLogin(); err != nil {
+		return 0, err
+	}
+
+	reader := &lib.CountingReader{Reader: fp}
+	partial := lib.PartialPath(path)
+
+	// filebrowser creates any missing directories
+	request, err := f.newRequest("POST", f.resourceURL(partial)+"?override=true", reader)
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		return reader.Count, fmt.Errorf("failed to upload file (wrote %d bytes): %w", reader.Count, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return reader.Count, fmt.Errorf("failed to upload file: %d", response.StatusCode)
+	}
+
+	if err = f.rename(partial, path); err != nil {
+		return 0, errors.Wrap(err, "failed to rename partial file to final destination")
+	}
+
+	return reader.Count, nil
+}
+
+// rename moves from to to, replacing anything already there.
+func (f *FileBrowser) rename(from, to string) error {
+	// filebrowser unescapes the destination again after reading the query
+	destination := (&url.URL{Path: "/" + strings.TrimLeft(to, "/")}).EscapedPath()
+	query := "?action=rename&override=true&destination=" + url.QueryEscape(destination)
+
+	response, err := f.request("PATCH", f.resourceURL(from)+query)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("failed to rename file: %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+func (f *FileBrowser) Delete(path string) error {
+	if err := f.ensureLogin(); err != nil {
+		return err
+	}
+
+	return f.delete(f.resourceURL(path))
+}
+
+func (f *FileBrowser) delete(apiPath string) error {
+	response, err := f.request("DELETE", apiPath)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("failed to delete file: %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// cleanDirectories deletes every empty directory under path, and path itself
+// if it's empty too, unless it's the root of the sync.
+func (f *FileBrowser) cleanDirectories(path string, isRoot bool) (isDeleted bool, err error) {
+	resource, err := f.getResource(path)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to list %s", path)
+	}
+
+	var hasChildren bool
+	for _, entry := range resource.Items {
+		if entry.IsDir {
+			dirPath := filepath.Join(path, entry.Name)
+			wasDeleted, err := f.cleanDirectories(dirPath, false)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to clean %s", dirPath)
+			}
+			if wasDeleted {
+				continue
+			}
+		}
+
+		hasChildren = true
+	}
+
+	if hasChildren || isRoot {
+		return false, nil
+	}
+
+	// deleting a directory deletes everything in it, so only ever delete
+	// one that was just seen to be empty
+	if err = f.delete(f.resourceURL(path) + "/"); err != nil {
+		return false, errors.Wrapf(err, "failed to remove %s", path)
+	}
+
+	return true, nil
+}
+
+func (f *FileBrowser) CleanDirectories(path string) error {
+	if err := f.ensureLogin(); err != nil {
+		return err
+	}
+
+	_, err := f.cleanDirectories(path, true)
+
+	return err
+}
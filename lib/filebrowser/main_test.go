@@ -1,13 +1,23 @@
 package filebrowser
 
 import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/djeebus/ftpsync/lib"
 )
 
 func getRequiredEnvVar(t *testing.T, key string) string {
@@ -27,7 +37,7 @@ func TestFileBrowser(t *testing.T) {
 
 	logger := logrus.New()
 
-	f, err := New(url, logger)
+	f, err := New(url, nil, lib.LinksSkip, logger)
 	require.NoError(t, err)
 
 	files, err := f.GetAllFiles(rootDir)
@@ -35,86 +45,163 @@ func TestFileBrowser(t *testing.T) {
 	assert.GreaterOrEqual(t, files.Len(), 1)
 }
 
-func TestFileBrowser_includeEntry(t *testing.T) {
+func TestFileBrowser_Read(t *testing.T) {
+	content := "hello world"
+
 	testCases := map[string]struct {
-		excludedGlobs []string
-		input         responseItem
-		shouldInclude bool
+		supportsRange bool
+		offset        int64
+		expected      string
 	}{
-		"exclude symlinks": {
-			input: responseItem{
-				IsSymlink: true,
-			},
-			shouldInclude: false,
-		},
-
-		"file matches glob": {
-			excludedGlobs: []string{"*something*"},
-			input: responseItem{
-				IsDir: true,
-				Path:  "abc/badtext",
-			},
-			shouldInclude: true,
-		},
-		"file does not match glob": {
-			excludedGlobs: []string{"*g*"},
-			input: responseItem{
-				Path: "abc/def",
-			},
-			shouldInclude: true,
-		},
-		"file subpath matches exact": {
-			excludedGlobs: []string{"*/b/*"},
-			input: responseItem{
-				Path: "a/b/c",
-			},
-			shouldInclude: false,
-		},
-		"file subpath matches partial": {
-			excludedGlobs: []string{"*b*"},
-			input: responseItem{
-				Path: "a/b/c",
-			},
-			shouldInclude: true,
-		},
-		"file exact path match": {
-			excludedGlobs: []string{"a"},
-			input: responseItem{
-				Path: "a",
-			},
-			shouldInclude: false,
-		},
-		"file partial match with pattern": {
-			excludedGlobs: []string{"*/*/*.c"},
-			input: responseItem{
-				Path: "a/b/abc.c",
-			},
-			shouldInclude: false,
-		},
-		"file and subdir partial match with pattern": {
-			excludedGlobs: []string{"*/b/*.c"},
-			input: responseItem{
-				Path: "a/b/abc.c",
-			},
-			shouldInclude: false,
-		},
-		"file partial match": {
-			excludedGlobs: []string{"*/*/c"},
-			input: responseItem{
-				Path: "a/b/c",
-			},
-			shouldInclude: false,
-		},
+		"full file":           {supportsRange: true, offset: 0, expected: content},
+		"ranged read":         {supportsRange: true, offset: 6, expected: "world"},
+		"range not supported": {supportsRange: false, offset: 6, expected: "world"},
 	}
 
 	for name, testCase := range testCases {
 		t.Run(name, func(t *testing.T) {
-			s := &FileBrowser{
-				excludedPatterns: testCase.excludedGlobs,
-				logger:           logrus.New(),
-			}
-			actual := s.includeEntry(testCase.input)
-			assert.Equal(t, testCase.shouldInclude, actual)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/raw/a/b.txt", r.URL.Path)
+				if testCase.supportsRange {
+					http.ServeContent(w, r, "b.txt", time.Time{}, strings.NewReader(content))
+					return
+				}
+				_, _ = w.Write([]byte(content))
+			}))
+			defer server.Close()
+
+			u, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			f := &FileBrowser{url: u, logger: logrus.New(), authCookie: "token"}
+
+			fp, err := f.Read("/a/b.txt", testCase.offset)
+			require.NoError(t, err)
+			defer fp.Close()
+
+			data, err := io.ReadAll(fp)
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expected, string(data))
 		})
 	}
 }
+
+// fakeServer is just enough of the filebrowser api to upload and delete
+// files.
+type fakeServer struct {
+	lock  sync.Mutex
+	files map[string]string
+	dirs  map[string]bool
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/api/resources")
+	if !ok || r.Header.Get("X-Auth") != "token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	isDir := path == "" || strings.HasSuffix(path, "/")
+	path = "/" + strings.Trim(path, "/")
+
+	switch r.Method {
+	case "GET":
+		if _, ok := s.files[path]; ok {
+			_ = json.NewEncoder(w).Encode(responseType{Path: path})
+			return
+		}
+		if !s.dirs[path] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		resource := responseType{IsDir: true, Path: path}
+		for name, content := range s.files {
+			if filepath.Dir(name) == path {
+				resource.Items = append(resource.Items, responseItem{Name: filepath.Base(name), Size: int64(len(content))})
+			}
+		}
+		for name := range s.dirs {
+			if name != "/" && filepath.Dir(name) == path {
+				resource.Items = append(resource.Items, responseItem{Name: filepath.Base(name), IsDir: true})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resource)
+	case "POST":
+		if r.URL.Query().Get("override") != "true" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		s.files[path] = string(data)
+		for dir := filepath.Dir(path); dir != "/"; dir = filepath.Dir(dir) {
+			s.dirs[dir] = true
+		}
+	case "PATCH":
+		query := r.URL.Query()
+		destination, err := url.QueryUnescape(query.Get("destination"))
+		content, ok := s.files[path]
+		if err != nil || !ok || query.Get("action") != "rename" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delete(s.files, path)
+		s.files[destination] = content
+	case "DELETE":
+		if !isDir {
+			delete(s.files, path)
+			return
+		}
+		for name := range s.files {
+			if strings.HasPrefix(name, path+"/") {
+				delete(s.files, name)
+			}
+		}
+		for name := range s.dirs {
+			if name == path || strings.HasPrefix(name, path+"/") {
+				delete(s.dirs, name)
+			}
+		}
+	}
+}
+
+func TestFileBrowser_Destination(t *testing.T) {
+	fake := &fakeServer{
+		files: map[string]string{"/old/gone.txt": "gone"},
+		dirs:  map[string]bool{"/": true, "/old": true, "/empty": true, "/empty/nested": true},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	f := &FileBrowser{url: u, logger: logrus.New(), authCookie: "token"}
+
+	size, err := f.Write("/a/b.txt", 0, io.NopCloser(strings.NewReader("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	size, err = f.Write("/a/b & c.txt", 0, io.NopCloser(strings.NewReader("done")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), size)
+	require.NoError(t, f.Delete("/a/b & c.txt"))
+
+	_, err = f.Write("/a/b.txt", 2, io.NopCloser(strings.NewReader("llo")))
+	assert.Error(t, err)
+
+	exists, err := f.Exists("/a/b.txt")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, f.Delete("/old/gone.txt"))
+	exists, err = f.Exists("/old/gone.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, f.CleanDirectories("/"))
+	assert.Equal(t, map[string]string{"/a/b.txt": "hello"}, fake.files)
+	assert.Equal(t, map[string]bool{"/": true, "/a": true}, fake.dirs)
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
+)
+
+// FilterOptions configures a Filter. Globs support ** and match the path
+// relative to the root being synced, or just the file name if they have no
+// slash in them.
+type FilterOptions struct {
+	Include []string
+	Exclude []string
+
+	IncludeRegex string
+	ExcludeRegex string
+
+	// MinSize and MaxSize are in bytes, and zero turns them off.
+	MinSize int64
+	MaxSize int64
+
+	// ExcludeHidden skips files and folders whose names start with a dot.
+	ExcludeHidden bool
+
+	// ServerExclude skips files whose whole path on the server, which is
+	// Root joined with the relative path, matches a filepath.Match glob.
+	// Leading slashes are ignored. It's how filebrowser's deprecated
+	// excluded query parameter always matched.
+	ServerExclude []string
+	Root          string
+}
+
+// Filter decides which files are synced. A nil Filter allows everything, so
+// every side of the sync has to use the same one, or excluded files would
+// look like they only exist on one side.
+type Filter struct {
+	include, exclude           []string
+	includeRegex, excludeRegex *regexp.Regexp
+	minSize, maxSize           int64
+	excludeHidden              bool
+	serverExclude              []string
+	root                       string
+}
+
+func NewFilter(opts FilterOptions) (*Filter, error) {
+	f := &Filter{
+		minSize:       opts.MinSize,
+		maxSize:       opts.MaxSize,
+		excludeHidden: opts.ExcludeHidden,
+		serverExclude: opts.ServerExclude,
+		root:          opts.Root,
+	}
+
+	var err error
+	if f.include, err = cleanPatterns(opts.Include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = cleanPatterns(opts.Exclude); err != nil {
+		return nil, err
+	}
+	if f.includeRegex, err = compileRegex(opts.IncludeRegex); err != nil {
+		return nil, err
+	}
+	if f.excludeRegex, err = compileRegex(opts.ExcludeRegex); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func cleanPatterns(patterns []string) ([]string, error) {
+	var cleaned []string
+	for _, pattern := range patterns {
+		pattern = strings.TrimLeft(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, errors.Errorf("invalid pattern: %q", pattern)
+		}
+
+		cleaned = append(cleaned, pattern)
+	}
+
+	return cleaned, nil
+}
+
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid regex %q", expr)
+	}
+
+	return re, nil
+}
+
+// Match reports whether the file at relPath should be synced.
+func (f *Filter) Match(relPath string, info FileInfo) bool {
+	if f == nil {
+		return true
+	}
+
+	relPath = filepath.ToSlash(relPath)
+
+	switch {
+	case f.excludeHidden && isHidden(relPath):
+		return false
+	case f.minSize > 0 && info.Size < f.minSize:
+		return false
+	case f.maxSize > 0 && info.Size > f.maxSize:
+		return false
+	case matchAny(f.exclude, relPath):
+		return false
+	case f.excludeRegex != nil && f.excludeRegex.MatchString(relPath):
+		return false
+	case f.matchServerExclude(relPath):
+		return false
+	}
+
+	if len(f.include) == 0 && f.includeRegex == nil {
+		return true
+	}
+
+	return matchAny(f.include, relPath) || (f.includeRegex != nil && f.includeRegex.MatchString(relPath))
+}
+
+// SkipDir reports whether nothing in the folder at relPath can be synced,
+// so walkers don't have to list it.
+func (f *Filter) SkipDir(relPath string) bool {
+	if f == nil {
+		return false
+	}
+
+	relPath = filepath.ToSlash(relPath)
+
+	return (f.excludeHidden && isHidden(relPath)) || matchAny(f.exclude, relPath)
+}
+
+func (f *Filter) matchServerExclude(relPath string) bool {
+	serverPath := strings.TrimLeft(path.Join(filepath.ToSlash(f.root), relPath), "/")
+
+	for _, pattern := range f.serverExclude {
+		if ok, _ := filepath.Match(strings.TrimLeft(pattern, "/"), serverPath); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchAny(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		name := relPath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(relPath)
+		}
+
+		if ok, _ := doublestar.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isHidden(relPath string) bool {
+	for _, part := range strings.Split(relPath, "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+
+	return false
+}
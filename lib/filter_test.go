@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	filter, err := NewFilter(FilterOptions{
+		Include:       []string{"movies/**", "*.mkv"},
+		Exclude:       []string{"**/sample/**", "*.nfo"},
+		ExcludeRegex:  `(?i)trailer`,
+		MinSize:       10,
+		MaxSize:       1000,
+		ExcludeHidden: true,
+	})
+	require.NoError(t, err)
+
+	testCases := map[string]bool{
+		"movies/a.mp4":          true,
+		"shows/b.mkv":           true,
+		"shows/b.mp4":           false,
+		"movies/a.nfo":          false,
+		"movies/sample/a.mp4":   false,
+		"movies/a-Trailer.mp4":  false,
+		"movies/.hidden.mp4":    false,
+		"movies/.cache/a.mp4":   false,
+		"movies/x/y/z/deep.mp4": true,
+	}
+	for path, expected := range testCases {
+		assert.Equal(t, expected, filter.Match(path, FileInfo{Size: 100}), path)
+	}
+
+	assert.False(t, filter.Match("movies/small.mp4", FileInfo{Size: 5}))
+	assert.False(t, filter.Match("movies/large.mp4", FileInfo{Size: 5000}))
+
+	assert.True(t, filter.SkipDir("movies/sample"))
+	assert.True(t, filter.SkipDir(".git"))
+	assert.False(t, filter.SkipDir("shows"))
+
+	var none *Filter
+	assert.True(t, none.Match(".anything", FileInfo{}))
+	assert.False(t, none.SkipDir(".git"))
+
+	_, err = NewFilter(FilterOptions{Exclude: []string{"[a-"}})
+	assert.Error(t, err)
+	_, err = NewFilter(FilterOptions{IncludeRegex: "("})
+	assert.Error(t, err)
+}
+
+func TestFilterServerExclude(t *testing.T) {
+	testCases := map[string]struct {
+		excludedGlobs []string
+		root          string
+		relPath       string
+		shouldInclude bool
+	}{
+		"file does not match glob": {
+			excludedGlobs: []string{"*g*"},
+			relPath:       "abc/def",
+			shouldInclude: true,
+		},
+		"file subpath matches exact": {
+			excludedGlobs: []string{"*/b/*"},
+			relPath:       "a/b/c",
+			shouldInclude: false,
+		},
+		"file subpath matches partial": {
+			excludedGlobs: []string{"*b*"},
+			relPath:       "a/b/c",
+			shouldInclude: true,
+		},
+		"file exact path match": {
+			excludedGlobs: []string{"a"},
+			relPath:       "a",
+			shouldInclude: false,
+		},
+		"file name doesn't match on its own": {
+			excludedGlobs: []string{"a"},
+			relPath:       "b/a",
+			shouldInclude: true,
+		},
+		"file partial match with pattern": {
+			excludedGlobs: []string{"*/*/*.c"},
+			relPath:       "a/b/abc.c",
+			shouldInclude: false,
+		},
+		"file and subdir partial match with pattern": {
+			excludedGlobs: []string{"*/b/*.c"},
+			relPath:       "a/b/abc.c",
+			shouldInclude: false,
+		},
+		"file partial match": {
+			excludedGlobs: []string{"*/*/c"},
+			relPath:       "a/b/c",
+			shouldInclude: false,
+		},
+		"absolute pattern under the root": {
+			excludedGlobs: []string{"/downloads/incomplete/*"},
+			root:          "/downloads",
+			relPath:       "incomplete/a.mkv",
+			shouldInclude: false,
+		},
+		"absolute pattern outside the root": {
+			excludedGlobs: []string{"/downloads/incomplete/*"},
+			root:          "/downloads",
+			relPath:       "complete/a.mkv",
+			shouldInclude: true,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			filter, err := NewFilter(FilterOptions{ServerExclude: testCase.excludedGlobs, Root: testCase.root})
+			require.NoError(t, err)
+
+			assert.Equal(t, testCase.shouldInclude, filter.Match(testCase.relPath, FileInfo{}))
+			assert.False(t, filter.SkipDir(testCase.relPath))
+		})
+	}
+}
+
+type fakeLister map[string]ListResult
+
+func (f fakeLister) List(path string) (ListResult, error) {
+	return f[path], nil
+}
+
+func TestWalkListerFilters(t *testing.T) {
+	lister := fakeLister{
+		"/root": {
+			Files:   map[string]FileInfo{"a.mkv": {Size: 1}, "a.nfo": {Size: 1}},
+			Folders: []string{"sub", ".git"},
+		},
+		"/root/sub": {Files: map[string]FileInfo{"b.mkv": {Size: 1}}},
+		"/root/.git": {
+			Files: map[string]FileInfo{"config": {Size: 1}},
+		},
+	}
+
+	filter, err := NewFilter(FilterOptions{Exclude: []string{"*.nfo"}, ExcludeHidden: true})
+	require.NoError(t, err)
+
+	files, err := WalkLister(lister, "/root", filter, LinksSkip)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/root/a.mkv", "/root/sub/b.mkv"}, files.ToSet().ToList())
+}
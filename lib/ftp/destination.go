@@ -0,0 +1,184 @@
+package ftp
+
+import (
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/pkg/errors"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+var (
+	_ lib.Destination   = new(source)
+	_ lib.ModTimeSetter = new(source)
+	_ lib.Stater        = new(source)
+)
+
+// isNotFound reports whether err is the server saying a file doesn't exist.
+func isNotFound(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable
+}
+
+func (f *source) GetPartialSize(path string) (int64, error) {
+	size, err := f.conn.FileSize(lib.PartialPath(f.toRemotePath(path)))
+	if isNotFound(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "failed to check partial file")
+	}
+
+	return size, nil
+}
+
+func (f *source) Exists(path string) (bool, error) {
+	_, err := f.conn.FileSize(f.toRemotePath(path))
+	if isNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "failed to check remote path")
+	}
+
+	return true, nil
+}
+
+func (f *source) Delete(path string) error {
+	path = f.toRemotePath(path)
+	if err := f.conn.Delete(path); err != nil {
+		return errors.Wrap(err, "failed to delete file")
+	}
+
+	if err := f.conn.Delete(lib.PartialPath(path)); err != nil && !isNotFound(err) {
+		return errors.Wrap(err, "failed to delete partial file")
+	}
+
+	return nil
+}
+
+func (f *source) Write(path string, offset int64, fp io.ReadCloser) (int64, error) {
+	path = f.toRemotePath(path)
+	partial := lib.PartialPath(path)
+
+	f.makeDirs(filepath.Dir(path))
+
+	reader := &lib.CountingReader{Reader: fp}
+
+	var err error
+	if offset > 0 {
+		err = f.conn.StorFrom(partial, reader, uint64(offset))
+	} else {
+		err = f.conn.Stor(partial, reader)
+	}
+	if err != nil {
+		return reader.Count, fmt.Errorf("failed to upload file (wrote %d bytes): %w", reader.Count, err)
+	}
+
+	if err = f.conn.Rename(partial, path); err != nil {
+		return 0, errors.Wrap(err, "failed to rename partial file to final destination")
+	}
+
+	return reader.Count, nil
+}
+
+// makeDirs creates every directory up to and including path. There's no
+// portable way to check whether a directory exists, so failures are
+// ignored, and the upload fails instead if one really couldn't be made.
+func (f *source) makeDirs(path string) {
+	var current string
+	if strings.HasPrefix(path, "/") {
+		current = "/"
+	}
+
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		_ = f.conn.MakeDir(current)
+	}
+}
+
+func (f *source) Stat(path string) (lib.FileInfo, error) {
+	path = f.toRemotePath(path)
+
+	size, err := f.conn.FileSize(path)
+	if err != nil {
+		return lib.FileInfo{}, errors.Wrap(err, "failed to get file size")
+	}
+
+	info := lib.FileInfo{Size: size}
+	if f.conn.IsGetTimeSupported() {
+		if info.ModTime, err = f.conn.GetTime(path); err != nil {
+			return lib.FileInfo{}, errors.Wrap(err, "failed to get modification time")
+		}
+	}
+
+	return info, nil
+}
+
+func (f *source) SetModTime(path string, modTime time.Time) error {
+	if !f.conn.IsSetTimeSupported() {
+		return nil
+	}
+
+	if err := f.conn.SetTime(f.toRemotePath(path), modTime); err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+
+	return nil
+}
+
+// cleanDirectories deletes every empty directory under path, and path itself
+// if it's empty too, unless it's the root of the sync.
+func (f *source) cleanDirectories(path string, isRoot bool) (isDeleted bool, err error) {
+	entries, err := f.conn.List(path)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+
+		return false, errors.Wrapf(err, "failed to list %s", path)
+	}
+
+	var hasChildren bool
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		if entry.Type == ftp.EntryTypeFolder {
+			dirPath := filepath.Join(path, entry.Name)
+			wasDeleted, err := f.cleanDirectories(dirPath, false)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to clean %s", dirPath)
+			}
+			if wasDeleted {
+				continue
+			}
+		}
+
+		hasChildren = true
+	}
+
+	if hasChildren || isRoot {
+		return false, nil
+	}
+
+	if err = f.conn.RemoveDir(path); err != nil {
+		return false, errors.Wrapf(err, "failed to remove %s", path)
+	}
+
+	return true, nil
+}
+
+func (f *source) CleanDirectories(path string) error {
+	_, err := f.cleanDirectories(f.toRemotePath(path), true)
+
+	return err
+}
@@ -0,0 +1,18 @@
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsNotFound(t *testing.T) {
+	assert.True(t, isNotFound(&textproto.Error{Code: 550, Msg: "No such file"}))
+	assert.True(t, isNotFound(fmt.Errorf("wrapped: %w", &textproto.Error{Code: 550})))
+	assert.False(t, isNotFound(&textproto.Error{Code: 530, Msg: "Not logged in"}))
+	assert.False(t, isNotFound(errors.New("connection reset")))
+	assert.False(t, isNotFound(nil))
+}
@@ -0,0 +1,192 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+// the ftp library doesn't expose FEAT or arbitrary commands, so checksums
+// are requested over a second control connection that never opens a data
+// connection.
+type hashConn struct {
+	conn     *textproto.Conn
+	features map[string]string
+}
+
+var hashNames = map[string]string{
+	lib.SHA256: "SHA-256",
+	lib.SHA1:   "SHA-1",
+	lib.MD5:    "MD5",
+}
+
+var hashCommands = map[string]string{
+	lib.SHA256: "XSHA256",
+	lib.SHA1:   "XSHA1",
+	lib.MD5:    "XMD5",
+}
+
+func dialHash(url *url.URL) (*hashConn, error) {
+	var (
+		netConn net.Conn
+		err     error
+	)
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if url.Scheme == "ftps-implicit" {
+		netConn, err = tls.DialWithDialer(dialer, "tcp", url.Host, &tls.Config{ServerName: url.Hostname()})
+	} else {
+		netConn, err = dialer.Dial("tcp", url.Host)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial ftp server")
+	}
+
+	c := &hashConn{conn: textproto.NewConn(netConn)}
+	if _, _, err = c.conn.ReadResponse(220); err != nil {
+		c.conn.Close()
+		return nil, errors.Wrap(err, "failed to read greeting")
+	}
+
+	if url.Scheme == "ftps" {
+		if _, _, err = c.cmd(234, "AUTH TLS"); err != nil {
+			c.conn.Close()
+			return nil, errors.Wrap(err, "failed to start tls")
+		}
+		c.conn = textproto.NewConn(tls.Client(netConn, &tls.Config{InsecureSkipVerify: true}))
+	}
+
+	password, _ := url.User.Password()
+	code, _, err := c.cmd(0, "USER %s", url.User.Username())
+	if err == nil && code == 331 {
+		_, _, err = c.cmd(230, "PASS %s", password)
+	} else if err == nil && code != 230 {
+		err = fmt.Errorf("unexpected response to USER: %d", code)
+	}
+	if err != nil {
+		c.conn.Close()
+		return nil, errors.Wrap(err, "failed to login")
+	}
+
+	_, message, err := c.cmd(211, "FEAT")
+	if err != nil {
+		// no FEAT means no hashing extensions either
+		message = ""
+	}
+	c.features = parseFeatures(message)
+
+	return c, nil
+}
+
+func (c *hashConn) cmd(expected int, format string, args ...any) (int, string, error) {
+	if _, err := c.conn.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+
+	return c.conn.ReadResponse(expected)
+}
+
+// parseFeatures reads the body of a FEAT response into a map of feature
+// names to their parameters.
+func parseFeatures(message string) map[string]string {
+	features := make(map[string]string)
+
+	lines := strings.Split(message, "\n")
+	for idx, line := range lines {
+		// the first and last lines are the "Features:" and "End" banners
+		if idx == 0 || idx == len(lines)-1 {
+			continue
+		}
+
+		name, params, _ := strings.Cut(strings.TrimSpace(line), " ")
+		if name == "" {
+			continue
+		}
+		features[strings.ToUpper(name)] = params
+	}
+
+	return features
+}
+
+func (c *hashConn) supportsHash(algorithm string) bool {
+	params, ok := c.features["HASH"]
+	if !ok {
+		return false
+	}
+
+	for _, name := range strings.Split(params, ";") {
+		if strings.TrimSuffix(name, "*") == hashNames[algorithm] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *hashConn) checksum(path string, algorithms ...string) (lib.Checksum, error) {
+	for _, algorithm := range algorithms {
+		if c.supportsHash(algorithm) {
+			if _, _, err := c.cmd(200, "OPTS HASH %s", hashNames[algorithm]); err != nil {
+				return lib.Checksum{}, errors.Wrapf(err, "failed to select %s", algorithm)
+			}
+
+			_, message, err := c.cmd(213, "HASH %s", path)
+			if err != nil {
+				return lib.Checksum{}, errors.Wrapf(err, "failed to hash %s", path)
+			}
+
+			return parseHashResponse(algorithm, message)
+		}
+
+		command := hashCommands[algorithm]
+		if _, ok := c.features[command]; ok {
+			_, message, err := c.cmd(213, "%s %s", command, path)
+			if err != nil {
+				return lib.Checksum{}, errors.Wrapf(err, "failed to hash %s", path)
+			}
+
+			return parseXHashResponse(algorithm, message)
+		}
+	}
+
+	return lib.Checksum{}, lib.ErrChecksumUnsupported
+}
+
+// parseHashResponse parses the response to HASH, which looks like
+// "SHA-256 0-49 169cd22282da7f147cb491e559e9dd filename".
+func parseHashResponse(algorithm, message string) (lib.Checksum, error) {
+	fields := strings.Fields(message)
+	if len(fields) < 3 {
+		return lib.Checksum{}, fmt.Errorf("invalid HASH response: %q", message)
+	}
+
+	if fields[0] != hashNames[algorithm] {
+		return lib.Checksum{}, fmt.Errorf("asked for %s, got %s", hashNames[algorithm], fields[0])
+	}
+
+	return lib.Checksum{Algorithm: algorithm, Value: strings.ToLower(fields[2])}, nil
+}
+
+// parseXHashResponse parses the response to XSHA256 and friends, which is
+// the hash, optionally followed by the filename.
+func parseXHashResponse(algorithm, message string) (lib.Checksum, error) {
+	fields := strings.Fields(message)
+	if len(fields) < 1 {
+		return lib.Checksum{}, fmt.Errorf("invalid hash response: %q", message)
+	}
+
+	return lib.Checksum{Algorithm: algorithm, Value: strings.ToLower(fields[0])}, nil
+}
+
+func (c *hashConn) Close() error {
+	_, _, _ = c.cmd(221, "QUIT")
+	return c.conn.Close()
+}
@@ -0,0 +1,37 @@
+package ftp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+func TestParseFeatures(t *testing.T) {
+	features := parseFeatures("Features:\n MDTM\n HASH SHA-1;SHA-256*;MD5\n XMD5\nEnd")
+
+	assert.Equal(t, map[string]string{
+		"MDTM": "",
+		"HASH": "SHA-1;SHA-256*;MD5",
+		"XMD5": "",
+	}, features)
+
+	c := &hashConn{features: features}
+	assert.True(t, c.supportsHash(lib.SHA256))
+	assert.True(t, c.supportsHash(lib.MD5))
+}
+
+func TestParseHashResponses(t *testing.T) {
+	checksum, err := parseHashResponse(lib.SHA256, "SHA-256 0-49 169CD22282DA7F147CB491E559E9DD filename.txt")
+	require.NoError(t, err)
+	assert.Equal(t, lib.Checksum{Algorithm: lib.SHA256, Value: "169cd22282da7f147cb491e559e9dd"}, checksum)
+
+	_, err = parseHashResponse(lib.SHA256, "MD5 0-49 169cd22282da7f147cb491e559e9dd filename.txt")
+	assert.Error(t, err)
+
+	checksum, err = parseXHashResponse(lib.MD5, "d41d8cd98f00b204e9800998ecf8427e")
+	require.NoError(t, err)
+	assert.Equal(t, lib.Checksum{Algorithm: lib.MD5, Value: "d41d8cd98f00b204e9800998ecf8427e"}, checksum)
+}
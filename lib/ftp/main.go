@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/jlaffaye/ftp"
 	"github.com/pkg/errors"
@@ -14,10 +15,29 @@ import (
 	"github.com/djeebus/ftpsync/lib"
 )
 
-func New(url *url.URL) (lib.Source, error) {
+func New(url *url.URL, filter *lib.Filter, links lib.LinkPolicy) (lib.Source, error) {
+	src, err := dial(url, filter, links)
+	if err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+// NewDestination connects to the server for uploads.
+func NewDestination(url *url.URL, filter *lib.Filter, links lib.LinkPolicy) (lib.Destination, error) {
+	dst, err := dial(url, filter, links)
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+func dial(url *url.URL, filter *lib.Filter, links lib.LinkPolicy) (*source, error) {
 	var opts = ftp.DialOption{}
 	switch url.Scheme {
-	case "sftp":
+	case "ftps-implicit":
 		opts = ftp.DialWithTLS(&tls.Config{
 			ServerName: url.Host,
 		})
@@ -38,16 +58,21 @@ func New(url *url.URL) (lib.Source, error) {
 		return nil, errors.Wrap(err, "failed to login")
 	}
 
-	return &source{conn: conn, root: url.Path}, nil
+	return &source{conn: conn, root: url.Path, url: url, filter: filter, links: links}, nil
 }
 
 type source struct {
-	root string
-	conn *ftp.ServerConn
+	root   string
+	conn   *ftp.ServerConn
+	filter *lib.Filter
+	links  lib.LinkPolicy
+
+	url      *url.URL
+	hashConn *hashConn
 }
 
 func (f *source) GetAllFiles(path string) (*lib.SizeSet, error) {
-	return lib.WalkLister(f, path)
+	return lib.WalkLister(f, path, f.filter, f.links)
 }
 
 func (f *source) toRemotePath(path string) string {
@@ -67,16 +92,19 @@ func (f *source) List(path string) (lib.ListResult, error) {
 	}
 
 	for _, entry := range entries {
-		entryPath := filepath.Join(path, entry.Name)
-
 		switch entry.Type {
 		case ftp.EntryTypeFolder:
-			result.Folders = append(result.Folders, entryPath)
+			result.Folders = append(result.Folders, entry.Name)
 		case ftp.EntryTypeFile:
-			result.Files[entryPath] = int64(entry.Size)
+			if lib.IsPartialPath(entry.Name) {
+				continue
+			}
+			result.Files[entry.Name] = lib.FileInfo{
+				Size:    int64(entry.Size),
+				ModTime: f.getModTime(rootPath, entry),
+			}
 		case ftp.EntryTypeLink:
-			// TODO: implement link handling
-			continue
+			result.Links[entry.Name] = entry.Target
 		default:
 			return result, fmt.Errorf("unknown file type for %s: %s", entry.Name, entry.Type.String())
 		}
@@ -85,10 +113,64 @@ func (f *source) List(path string) (lib.ListResult, error) {
 	return result, nil
 }
 
-func (f *source) Read(path string) (io.ReadCloser, error) {
+func (f *source) ServerPath(path string) string {
+	return f.toRemotePath(path)
+}
+
+// StatLink asks the server about the link's target with MLST, or failing
+// that, treats it as a file if it has a size and a directory if it can be
+// listed.
+func (f *source) StatLink(path string) (lib.FileInfo, bool, error) {
+	remotePath := f.toRemotePath(path)
+
+	if entry, err := f.conn.GetEntry(remotePath); err == nil {
+		switch entry.Type {
+		case ftp.EntryTypeFolder:
+			return lib.FileInfo{}, true, nil
+		case ftp.EntryTypeFile:
+			return lib.FileInfo{Size: int64(entry.Size), ModTime: entry.Time}, false, nil
+		}
+	}
+
+	size, err := f.conn.FileSize(remotePath)
+	if err != nil {
+		if _, err = f.conn.List(remotePath); err != nil {
+			return lib.FileInfo{}, false, errors.Wrapf(err, "failed to follow %s", path)
+		}
+		return lib.FileInfo{}, true, nil
+	}
+
+	var modTime time.Time
+	if f.conn.IsGetTimeSupported() {
+		modTime, _ = f.conn.GetTime(remotePath)
+	}
+
+	return lib.FileInfo{Size: size, ModTime: modTime}, false, nil
+}
+
+// getModTime returns the time from the listing when it came from MLSD, and
+// asks for it with MDTM otherwise, as LIST times are missing the seconds and
+// sometimes the year.
+func (f *source) getModTime(rootPath string, entry *ftp.Entry) time.Time {
+	if f.conn.IsTimePreciseInList() {
+		return entry.Time
+	}
+	if !f.conn.IsGetTimeSupported() {
+		return time.Time{}
+	}
+
+	modTime, err := f.conn.GetTime(filepath.Join(rootPath, entry.Name))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return modTime
+}
+
+func (f *source) Read(path string, offset int64) (io.ReadCloser, error) {
 	path = f.toRemotePath(path)
 
-	e, err := f.conn.Retr(path)
+	e, err := f.conn.RetrFrom(path, uint64(offset))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to download file")
 	}
@@ -96,8 +178,28 @@ func (f *source) Read(path string) (io.ReadCloser, error) {
 	return e, nil
 }
 
+func (f *source) Checksum(path string, algorithms ...string) (lib.Checksum, error) {
+	if f.hashConn == nil {
+		conn, err := dialHash(f.url)
+		if err != nil {
+			return lib.Checksum{}, errors.Wrap(err, "failed to open connection for checksums")
+		}
+		f.hashConn = conn
+	}
+
+	return f.hashConn.checksum(f.toRemotePath(path), algorithms...)
+}
+
 func (f *source) Close() error {
+	if f.hashConn != nil {
+		_ = f.hashConn.Close()
+	}
+
 	return f.conn.Quit()
 }
 
-var _ lib.Source = new(source)
+var (
+	_ lib.Source       = new(source)
+	_ lib.Checksummer  = new(source)
+	_ lib.LinkResolver = new(source)
+)
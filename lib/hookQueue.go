@@ -0,0 +1,40 @@
+package lib
+
+// fileHookQueueSize is how many downloaded files can wait for their hooks
+// before downloads start waiting too.
+const fileHookQueueSize = 100
+
+// hookQueue runs file hooks one at a time in the background, so slow hooks
+// don't hold up the downloads.
+type hookQueue struct {
+	events chan FileEvent
+	done   chan struct{}
+}
+
+// startFileHooks returns nil if there are no hooks to run.
+func (p *Processor) startFileHooks() *hookQueue {
+	if len(p.hooks) == 0 {
+		return nil
+	}
+
+	queue := &hookQueue{events: make(chan FileEvent, fileHookQueueSize), done: make(chan struct{})}
+
+	go func() {
+		defer close(queue.done)
+		for event := range queue.events {
+			p.runFileHooks(event)
+		}
+	}()
+
+	return queue
+}
+
+// wait blocks until every queued hook has run.
+func (q *hookQueue) wait() {
+	if q == nil {
+		return
+	}
+
+	close(q.events)
+	<-q.done
+}
@@ -0,0 +1,176 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const defaultTimeout = time.Minute
+
+type Options struct {
+	Job string
+	// LocalRoot is joined to the local path of every file, when the
+	// destination is a local folder.
+	LocalRoot string
+
+	// FileCommand and FileURL run after every download, SyncCommand and
+	// SyncURL after every sync. Commands run with sh -c.
+	FileCommand string
+	FileURL     string
+	SyncCommand string
+	SyncURL     string
+
+	Timeout time.Duration
+}
+
+// Hooks runs commands and posts to webhooks.
+type Hooks struct {
+	opts   Options
+	client http.Client
+}
+
+var _ lib.Hook = new(Hooks)
+
+func New(opts Options) *Hooks {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+
+	return &Hooks{opts: opts, client: http.Client{Timeout: opts.Timeout}}
+}
+
+// payload is what webhooks are sent, and commands get the same fields as
+// FTPSYNC_ environment variables.
+type payload struct {
+	Event string `json:"event"`
+	Job   string `json:"job"`
+
+	Path      string `json:"path,omitempty"`
+	LocalPath string `json:"local_path,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+
+	Root            string  `json:"root,omitempty"`
+	Downloaded      int64   `json:"downloaded,omitempty"`
+	Failed          int64   `json:"failed,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+func (p payload) environ() []string {
+	env := []string{
+		"FTPSYNC_EVENT=" + p.Event,
+		"FTPSYNC_JOB=" + p.Job,
+	}
+
+	if p.Event == "file" {
+		return append(env,
+			"FTPSYNC_PATH="+p.Path,
+			"FTPSYNC_LOCAL_PATH="+p.LocalPath,
+			fmt.Sprintf("FTPSYNC_SIZE=%d", p.Size),
+		)
+	}
+
+	return append(env,
+		"FTPSYNC_ROOT="+p.Root,
+		fmt.Sprintf("FTPSYNC_DOWNLOADED=%d", p.Downloaded),
+		fmt.Sprintf("FTPSYNC_FAILED=%d", p.Failed),
+		fmt.Sprintf("FTPSYNC_DURATION_SECONDS=%.3f", p.DurationSeconds),
+		"FTPSYNC_ERROR="+p.Error,
+	)
+}
+
+func (h *Hooks) FileDownloaded(event lib.FileEvent) error {
+	localPath := event.LocalPath
+	if h.opts.LocalRoot != "" {
+		localPath = filepath.Join(h.opts.LocalRoot, strings.TrimLeft(localPath, "/"))
+	}
+
+	return h.run(h.opts.FileCommand, h.opts.FileURL, payload{
+		Event:     "file",
+		Job:       h.opts.Job,
+		Path:      event.Path,
+		LocalPath: localPath,
+		Size:      event.Size,
+	})
+}
+
+func (h *Hooks) SyncFinished(event lib.SyncEvent) error {
+	return h.run(h.opts.SyncCommand, h.opts.SyncURL, payload{
+		Event:           "sync",
+		Job:             h.opts.Job,
+		Root:            event.Root,
+		Downloaded:      event.Downloaded,
+		Failed:          event.Failed,
+		DurationSeconds: event.Duration.Seconds(),
+		Error:           event.Error,
+	})
+}
+
+// run runs command and posts to url, if they're set, even if the first
+// one fails.
+func (h *Hooks) run(command, url string, p payload) error {
+	var failures []string
+
+	if command != "" {
+		if err := h.runCommand(command, p); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if url != "" {
+		if err := h.post(url, p); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+func (h *Hooks) runCommand(command string, p payload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), p.environ()...)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "command failed: %s", strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func (h *Hooks) post(url string, p payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal payload")
+	}
+
+	response, err := h.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to post to webhook")
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("webhook returned %d", response.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package hooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+func TestFileCommand(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output")
+
+	h := New(Options{
+		Job:         "movies",
+		LocalRoot:   "/media",
+		FileCommand: `echo "$FTPSYNC_EVENT $FTPSYNC_JOB $FTPSYNC_PATH $FTPSYNC_LOCAL_PATH $FTPSYNC_SIZE" > ` + output,
+	})
+	require.NoError(t, h.FileDownloaded(lib.FileEvent{Path: "/a/b.mkv", LocalPath: "/a/b.mkv", Size: 5}))
+
+	data, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "file movies /a/b.mkv /media/a/b.mkv 5\n", string(data))
+
+	// sync hooks aren't set, so this does nothing
+	require.NoError(t, h.SyncFinished(lib.SyncEvent{}))
+}
+
+func TestWebhook(t *testing.T) {
+	var received []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received = append(received, body)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	h := New(Options{Job: "movies", SyncURL: server.URL + "/sync", FileURL: server.URL + "/fail"})
+	require.NoError(t, h.SyncFinished(lib.SyncEvent{Root: "/movies", Downloaded: 2, Duration: 1500 * time.Millisecond, Error: "oops"}))
+	assert.EqualError(t, h.FileDownloaded(lib.FileEvent{Path: "/a.mkv"}), "webhook returned 500")
+
+	require.Len(t, received, 2)
+	assert.Equal(t, map[string]any{
+		"event":            "sync",
+		"job":              "movies",
+		"root":             "/movies",
+		"downloaded":       2.0,
+		"duration_seconds": 1.5,
+		"error":            "oops",
+	}, received[0])
+}
+
+func TestFailuresAreCombined(t *testing.T) {
+	h := New(Options{SyncCommand: "echo broken; exit 3", SyncURL: "http://127.0.0.1:1/sync", Timeout: time.Second})
+
+	err := h.SyncFinished(lib.SyncEvent{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "command failed: broken")
+	assert.Contains(t, err.Error(), "failed to post to webhook")
+}
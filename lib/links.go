@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LinkPolicy is what listings do with symlinks.
+type LinkPolicy string
+
+const (
+	// LinksSkip leaves symlinks out.
+	LinksSkip LinkPolicy = "skip"
+	// LinksFollow lists what symlinks point to as if it were there, and
+	// stops at links that would loop back on themselves. Directory links
+	// are skipped if the server doesn't say where they point.
+	LinksFollow LinkPolicy = "follow"
+	// LinksRecreate lists symlinks that point inside the synced directory
+	// with their target in FileInfo.Link, so they can be recreated.
+	LinksRecreate LinkPolicy = "recreate"
+)
+
+// maxLinkDepth stops following chains of directory links that are too
+// deep, even if they don't loop.
+const maxLinkDepth = 8
+
+// LinkResolver is implemented by listers that can follow symlinks.
+type LinkResolver interface {
+	// ServerPath returns path the way the server sees it, which is what
+	// link targets are relative to.
+	ServerPath(path string) string
+	// StatLink describes what the symlink at path points to.
+	StatLink(path string) (info FileInfo, isDir bool, err error)
+}
+
+// Linker is implemented by destinations that can create symlinks.
+type Linker interface {
+	Symlink(path, target string) error
+}
+
+// resolveTarget returns the server path a link in dir points to, or an empty
+// string if either isn't known.
+func resolveTarget(dir, target string) string {
+	switch {
+	case target == "":
+		return ""
+	case filepath.IsAbs(target):
+		return filepath.Clean(target)
+	case dir == "":
+		return ""
+	default:
+		return filepath.Join(dir, target)
+	}
+}
+
+// isWithin reports whether path is dir, or somewhere under it.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, "../"))
+}
+
+// createLink recreates the remote symlink at path locally, pointing at
+// target.
+func (p *Processor) createLink(path, target string) error {
+	linker, ok := p.local.(Linker)
+	if !ok {
+		return errors.New("destination can't create symlinks")
+	}
+
+	if err := linker.Symlink(p.localPath(path), target); err != nil {
+		return errors.Wrapf(err, "failed to link %s", path)
+	}
+
+	p.log.WithField("path", path).WithField("target", target).Info("created link")
+
+	if err := p.db.Record(path); err != nil {
+		return errors.Wrapf(err, "failed to record %s", path)
+	}
+
+	return p.recordLocalPath(path)
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLinkLister is a fakeLister that lives at /srv on the server, and
+// resolves links with stats.
+type fakeLinkLister struct {
+	fakeLister
+	stats map[string]FileInfo
+	dirs  map[string]bool
+}
+
+func (f fakeLinkLister) ServerPath(path string) string {
+	return filepath.Join("/srv", path)
+}
+
+func (f fakeLinkLister) StatLink(path string) (FileInfo, bool, error) {
+	if f.dirs[path] {
+		return FileInfo{}, true, nil
+	}
+	if info, ok := f.stats[path]; ok {
+		return info, false, nil
+	}
+	return FileInfo{}, false, errors.New("broken link")
+}
+
+func newFakeLinkLister() fakeLinkLister {
+	return fakeLinkLister{
+		fakeLister: fakeLister{
+			"/root": {
+				Files:   map[string]FileInfo{"a.mkv": {Size: 1}},
+				Folders: []string{"complete"},
+				Links: map[string]string{
+					"latest.mkv": "complete/b.mkv",
+					"loop":       "/srv/root",
+					"outside":    "/elsewhere/c.mkv",
+					"broken":     "missing.mkv",
+					"shows":      "complete",
+				},
+			},
+			"/root/complete": {
+				Files: map[string]FileInfo{"b.mkv": {Size: 2}},
+				Links: map[string]string{"up": ".."},
+			},
+			"/root/shows": {
+				Files: map[string]FileInfo{"b.mkv": {Size: 2}},
+				Links: map[string]string{"up": ".."},
+			},
+		},
+		stats: map[string]FileInfo{
+			"/root/latest.mkv": {Size: 2},
+			"/root/outside":    {Size: 3},
+		},
+		dirs: map[string]bool{
+			"/root/loop":        true,
+			"/root/shows":       true,
+			"/root/complete/up": true,
+			"/root/shows/up":    true,
+		},
+	}
+}
+
+func TestWalkListerLinks(t *testing.T) {
+	lister := newFakeLinkLister()
+
+	t.Run("skip", func(t *testing.T) {
+		files, err := WalkLister(lister, "/root", nil, LinksSkip)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"/root/a.mkv", "/root/complete/b.mkv"}, files.ToSet().ToList())
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		files, err := WalkLister(lister, "/root", nil, LinksFollow)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{
+			"/root/a.mkv",
+			"/root/complete/b.mkv",
+			"/root/latest.mkv",
+			"/root/outside",
+			"/root/shows/b.mkv",
+		}, files.ToSet().ToList())
+
+		info, _ := files.GetInfo("/root/latest.mkv")
+		assert.Equal(t, FileInfo{Size: 2}, info)
+	})
+
+	t.Run("recreate", func(t *testing.T) {
+		files, err := WalkLister(lister, "/root", nil, LinksRecreate)
+		require.NoError(t, err)
+
+		links := make(map[string]string)
+		for _, path := range files.ToSet().ToList() {
+			info, _ := files.GetInfo(path)
+			links[path] = info.Link
+		}
+		assert.Equal(t, map[string]string{
+			"/root/a.mkv":          "",
+			"/root/complete/b.mkv": "",
+			"/root/latest.mkv":     "complete/b.mkv",
+			"/root/loop":           ".",
+			"/root/broken":         "missing.mkv",
+			"/root/shows":          "complete",
+			"/root/complete/up":    "..",
+		}, links)
+	})
+}
+
+func TestWalkListerSkipsUnknownDirectoryLinks(t *testing.T) {
+	// a server that doesn't say where links point
+	lister := fakeLinkLister{
+		fakeLister: fakeLister{},
+		stats:      map[string]FileInfo{"/root/b.mkv": {Size: 2}},
+		dirs:       map[string]bool{},
+	}
+
+	path := "/root"
+	for i := 0; i < 20; i++ {
+		lister.fakeLister[path] = ListResult{
+			Files: map[string]FileInfo{"a.mkv": {Size: 1}},
+			Links: map[string]string{"self": "", "b.mkv": ""},
+		}
+		lister.dirs[path+"/self"] = true
+		path += "/self"
+	}
+
+	// file links can't loop, so they're still followed
+	files, err := WalkLister(lister, "/root", nil, LinksFollow)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/root/a.mkv", "/root/b.mkv"}, files.ToSet().ToList())
+}
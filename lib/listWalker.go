@@ -7,13 +7,18 @@ import (
 )
 
 type ListResult struct {
-	Files   map[string]int64
+	Files   map[string]FileInfo
 	Folders []string
+	// Links maps the name of every symlink to its target, as the server
+	// gave it. Targets can be relative, or empty when the server doesn't
+	// say.
+	Links map[string]string
 }
 
 func NewListResult() ListResult {
 	return ListResult{
-		Files: make(map[string]int64),
+		Files: make(map[string]FileInfo),
+		Links: make(map[string]string),
 	}
 }
 
@@ -21,14 +26,36 @@ type Lister interface {
 	List(path string) (ListResult, error)
 }
 
-func WalkLister(lister Lister, rootPath string) (*SizeSet, error) {
+// walkItem is a directory waiting to be listed.
+type walkItem struct {
+	path string
+	// real is where path is on the server, if it's known.
+	real string
+	// chain is where the root and every directory link followed to get
+	// here are, for finding loops.
+	chain []string
+	links int
+}
+
+// WalkLister lists every file under rootPath that filter allows, and
+// handles symlinks according to links.
+func WalkLister(lister Lister, rootPath string, filter *Filter, links LinkPolicy) (*SizeSet, error) {
 	result := NewSizeSet()
 
-	work := Queue[string]{MaxSize: 1000}
-	work.Enqueue(rootPath)
+	resolver, _ := lister.(LinkResolver)
+
+	root := walkItem{path: rootPath}
+	if resolver != nil {
+		root.real = resolver.ServerPath(rootPath)
+		root.chain = []string{root.real}
+	}
+
+	work := Queue[walkItem]{MaxSize: 1000}
+	work.Enqueue(root)
 
 	for !work.IsEmpty() {
-		path := work.Dequeue()
+		item := work.Dequeue()
+		path := item.path
 
 		results, err := lister.List(path)
 		if err != nil {
@@ -37,14 +64,102 @@ func WalkLister(lister Lister, rootPath string) (*SizeSet, error) {
 
 		for _, d := range results.Folders {
 			fullpath := filepath.Join(path, d)
-			work.Enqueue(fullpath)
+			if filter.SkipDir(relativePath(rootPath, fullpath)) {
+				continue
+			}
+
+			next := walkItem{path: fullpath, chain: item.chain, links: item.links}
+			if item.real != "" {
+				next.real = filepath.Join(item.real, d)
+			}
+			work.Enqueue(next)
 		}
 
-		for filename, size := range results.Files {
+		for filename, info := range results.Files {
 			fullPath := filepath.Join(path, filename)
-			result.Set(fullPath, size)
+			if !filter.Match(relativePath(rootPath, fullPath), info) {
+				continue
+			}
+			result.SetInfo(fullPath, info)
+		}
+
+		if resolver == nil {
+			continue
+		}
+
+		for name, target := range results.Links {
+			fullPath := filepath.Join(path, name)
+			target = resolveTarget(item.real, target)
+
+			switch links {
+			case LinksRecreate:
+				// only links inside the synced directory still work once
+				// they're recreated
+				if target == "" || !isWithin(root.real, target) {
+					continue
+				}
+
+				link, err := filepath.Rel(item.real, target)
+				if err != nil {
+					continue
+				}
+
+				info := FileInfo{Link: link}
+				if filter.Match(relativePath(rootPath, fullPath), info) {
+					result.SetInfo(fullPath, info)
+				}
+
+			case LinksFollow:
+				info, isDir, err := resolver.StatLink(fullPath)
+				if err != nil {
+					// broken links have nothing to sync
+					continue
+				}
+
+				if !isDir {
+					if filter.Match(relativePath(rootPath, fullPath), info) {
+						result.SetInfo(fullPath, info)
+					}
+					continue
+				}
+
+				// without the target there's no telling whether the link
+				// loops, so it's skipped
+				if target == "" || filter.SkipDir(relativePath(rootPath, fullPath)) || isLoop(item, target) {
+					continue
+				}
+
+				next := walkItem{path: fullPath, real: target, links: item.links + 1}
+				next.chain = append(append([]string(nil), item.chain...), target)
+				work.Enqueue(next)
+			}
 		}
 	}
 
 	return result, nil
 }
+
+// isLoop reports whether following a directory link from item to target
+// would list something that's already being listed.
+func isLoop(item walkItem, target string) bool {
+	if item.links >= maxLinkDepth {
+		return true
+	}
+
+	for _, dir := range append([]string{item.real}, item.chain...) {
+		if dir != "" && isWithin(target, dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func relativePath(rootPath, path string) string {
+	rel, err := filepath.Rel(rootPath, path)
+	if err != nil {
+		return path
+	}
+
+	return rel
+}
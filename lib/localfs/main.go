@@ -7,28 +7,53 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/djeebus/ftpsync/lib/config"
+	"github.com/djeebus/ftpsync/lib/extract"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/djeebus/ftpsync/lib"
 )
 
-func New(config config.Config, logger logrus.FieldLogger) (*LocalFS, error) {
+func New(config config.Config, filter *lib.Filter, logger logrus.FieldLogger) (*LocalFS, error) {
+	root, ok := config.LocalDestination()
+	if !ok {
+		return nil, errors.New("destination is not a local directory")
+	}
+
 	return &LocalFS{
 		logger:      logger,
-		root:        config.Destination,
+		filter:      filter,
+		links:       lib.LinkPolicy(config.Links),
+		root:        root,
 		dirMode:     config.DirMode,
 		dirGroupID:  config.DirGroupID,
 		dirUserID:   config.DirUserID,
 		fileMode:    config.FileMode,
 		fileUserID:  config.FileUserID,
 		fileGroupID: config.FileGroupID,
+
+		trashDir:       config.TrashDir,
+		trashRetention: time.Duration(config.TrashRetention),
+		now:            time.Now,
+		rename:         os.Rename,
 	}, nil
 }
 
-var _ lib.Destination = new(LocalFS)
+var (
+	_ lib.Source        = new(LocalFS)
+	_ lib.Destination   = new(LocalFS)
+	_ lib.Checksummer   = new(LocalFS)
+	_ lib.ModTimeSetter = new(LocalFS)
+	_ lib.Renamer       = new(LocalFS)
+	_ lib.Stater        = new(LocalFS)
+	_ lib.Extractor     = new(LocalFS)
+	_ lib.LinkResolver  = new(LocalFS)
+	_ lib.Linker        = new(LocalFS)
+)
 
 type LocalFS struct {
 	dirMode  fs.FileMode
@@ -42,47 +67,117 @@ type LocalFS struct {
 
 	logger logrus.FieldLogger
 	root   string
-}
+	filter *lib.Filter
+	links  lib.LinkPolicy
 
-func (l *LocalFS) getFsys() fs.FS {
-	// so much trimming required to get FS to work
-	root := strings.TrimRight(l.root, "/")
-	fsys := os.DirFS(root)
-	return fsys
+	trashDir       string
+	trashRetention time.Duration
+	now            func() time.Time
+	rename         func(from, to string) error
 }
 
+// trashLayout names the directory in the trash that files deleted at the
+// same time are moved to.
+const trashLayout = "20060102T150405Z"
+
 func (l *LocalFS) GetAllFiles(rootPath string) (*lib.SizeSet, error) {
-	fsys := l.getFsys()
+	rootPath = "/" + strings.Trim(rootPath, "/")
+
+	files, err := lib.WalkLister(l, rootPath, l.filter, l.links)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to walk [%s, %s]", l.root, rootPath)
+	}
 
-	rootPath = strings.TrimLeft(rootPath, "/")
-	rootPath = strings.TrimRight(rootPath, "/")
+	return files, nil
+}
 
-	files := lib.NewSizeSet()
-	if err := fs.WalkDir(fsys, rootPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			if os.IsNotExist(err) {
-				return nil
+func (l *LocalFS) List(path string) (lib.ListResult, error) {
+	result := lib.NewListResult()
+
+	localPath := l.toLocalPath(path)
+
+	entries, err := os.ReadDir(localPath)
+	if os.IsNotExist(err) {
+		return result, nil
+	} else if err != nil {
+		return result, errors.Wrapf(err, "error at %s", path)
+	}
+
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir():
+			if !l.isTrash(filepath.Join(localPath, entry.Name())) {
+				result.Folders = append(result.Folders, entry.Name())
+			}
+		case entry.Type()&fs.ModeSymlink != 0:
+			if l.links == lib.LinksSkip {
+				continue
 			}
 
-			return errors.Wrapf(err, "error at %s", path)
-		}
+			target, err := os.Readlink(filepath.Join(localPath, entry.Name()))
+			if err != nil {
+				l.logger.WithError(err).WithField("path", filepath.Join(path, entry.Name())).Warning("failed to read link, skipping it")
+				continue
+			}
+			result.Links[entry.Name()] = target
+		case entry.Type().IsRegular():
+			if lib.IsPartialPath(entry.Name()) {
+				continue
+			}
 
-		if d.IsDir() {
-			return nil
+			info, err := entry.Info()
+			if err != nil {
+				return result, errors.Wrapf(err, "failed to read info %s", entry.Name())
+			}
+			result.Files[entry.Name()] = lib.FileInfo{Size: info.Size(), ModTime: info.ModTime()}
 		}
+	}
 
-		info, err := d.Info()
-		if err != nil {
-			return errors.Wrapf(err, "failed to read info %s", path)
+	return result, nil
+}
+
+func (l *LocalFS) ServerPath(path string) string {
+	return l.toLocalPath(path)
+}
+
+func (l *LocalFS) StatLink(path string) (lib.FileInfo, bool, error) {
+	info, err := os.Stat(l.toLocalPath(path))
+	if err != nil {
+		return lib.FileInfo{}, false, errors.Wrapf(err, "failed to follow %s", path)
+	}
+
+	if info.IsDir() {
+		return lib.FileInfo{}, true, nil
+	}
+
+	return lib.FileInfo{Size: info.Size(), ModTime: info.ModTime()}, false, nil
+}
+
+// Symlink creates a link at path pointing to target, which is left as is.
+func (l *LocalFS) Symlink(path, target string) error {
+	localPath := l.toLocalPath(path)
+
+	dirname := filepath.Dir(localPath)
+	if err := os.MkdirAll(dirname, l.dirMode); err != nil {
+		return errors.Wrap(err, "failed to create directory")
+	}
+	if l.dirUserID != 0 && l.dirGroupID != 0 {
+		if err := os.Chown(dirname, int(l.dirUserID), int(l.dirGroupID)); err != nil {
+			return errors.Wrap(err, "failed to chown directory")
 		}
+	}
 
-		files.Set("/"+path, info.Size())
-		return nil
-	}); err != nil {
-		return nil, errors.Wrapf(err, "failed to walk [%s, %s]", l.root, rootPath)
+	if err := os.Symlink(target, localPath); err != nil {
+		return errors.Wrap(err, "failed to create symlink")
 	}
 
-	return files, nil
+	if l.fileUserID != 0 && l.fileGroupID != 0 {
+		if err := os.Lchown(localPath, int(l.fileUserID), int(l.fileGroupID)); err != nil {
+			return errors.Wrap(err, "failed to set link owner")
+		}
+	}
+
+	return nil
 }
 
 func (l *LocalFS) toLocalPath(path string) string {
@@ -103,16 +198,301 @@ func (l *LocalFS) Exists(path string) (bool, error) {
 	}
 }
 
+// Read lets LocalFS act as the source for uploads.
+func (l *LocalFS) Read(path string, offset int64) (io.ReadCloser, error) {
+	fp, err := os.Open(l.toLocalPath(path))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+
+	if _, err = fp.Seek(offset, io.SeekStart); err != nil {
+		l.safelyClose(fp)
+		return nil, errors.Wrapf(err, "failed to seek to %d", offset)
+	}
+
+	return fp, nil
+}
+
+func (l *LocalFS) Close() error {
+	return nil
+}
+
+func (l *LocalFS) Checksum(path string, algorithms ...string) (lib.Checksum, error) {
+	if len(algorithms) == 0 {
+		return lib.Checksum{}, lib.ErrChecksumUnsupported
+	}
+
+	fp, err := os.Open(l.toLocalPath(path))
+	if err != nil {
+		return lib.Checksum{}, errors.Wrap(err, "failed to open file")
+	}
+	defer l.safelyClose(fp)
+
+	return lib.HashReader(fp, algorithms[0])
+}
+
+func (l *LocalFS) Stat(path string) (lib.FileInfo, error) {
+	info, err := os.Stat(l.toLocalPath(path))
+	if err != nil {
+		return lib.FileInfo{}, errors.Wrap(err, "failed to stat file")
+	}
+
+	return lib.FileInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *LocalFS) Rename(from, to string) error {
+	if err := os.Rename(l.toLocalPath(from), l.toLocalPath(to)); err != nil {
+		return errors.Wrap(err, "failed to rename file")
+	}
+
+	return nil
+}
+
+func (l *LocalFS) IsArchive(path string) bool {
+	return extract.IsArchive(path)
+}
+
+func (l *LocalFS) Extract(path string) ([]string, error) {
+	localPath := l.toLocalPath(path)
+	dir := filepath.Dir(localPath)
+
+	names, err := extract.Extract(localPath, dir, l.dirMode, l.fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(names))
+	for _, name := range names {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+
+		if l.dirUserID != 0 && l.dirGroupID != 0 {
+			err = os.Chown(filepath.Dir(target), int(l.dirUserID), int(l.dirGroupID))
+		}
+		if err == nil {
+			err = l.setFileMode(target)
+		}
+		if err != nil {
+			// nothing is recorded as extracted, so don't leave any of it behind
+			for _, name := range names {
+				os.Remove(filepath.Join(dir, filepath.FromSlash(name)))
+			}
+			return nil, errors.Wrapf(err, "failed to extract %s", name)
+		}
+
+		files = append(files, filepath.Join(filepath.Dir(path), name))
+	}
+
+	return files, nil
+}
+
+func (l *LocalFS) SetModTime(path string, modTime time.Time) error {
+	if err := os.Chtimes(l.toLocalPath(path), time.Time{}, modTime); err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+
+	return nil
+}
+
+func (l *LocalFS) GetPartialSize(path string) (int64, error) {
+	path = lib.PartialPath(l.toLocalPath(path))
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "failed to check partial file")
+	}
+
+	return info.Size(), nil
+}
+
+// isTrash reports whether localPath is the trash, so it can be skipped when
+// the trash is inside the destination.
+func (l *LocalFS) isTrash(localPath string) bool {
+	if l.trashDir == "" {
+		return false
+	}
+
+	trash, err := filepath.Abs(l.trashDir)
+	if err != nil {
+		return false
+	}
+	localPath, err = filepath.Abs(localPath)
+	if err != nil {
+		return false
+	}
+
+	return trash == localPath
+}
+
 func (l *LocalFS) Delete(path string) error {
-	path = l.toLocalPath(path)
-	if err := os.Remove(path); err != nil {
+	if l.trashDir == "" {
+		return l.Discard(path)
+	}
+
+	if err := l.removePartial(path); err != nil {
+		return err
+	}
+
+	return l.moveToTrash(path)
+}
+
+// Discard deletes path without moving it to the trash.
+func (l *LocalFS) Discard(path string) error {
+	if err := l.removePartial(path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(l.toLocalPath(path)); err != nil {
 		return errors.Wrap(err, "failed to delete file")
 	}
 
 	return nil
 }
 
-func (l *LocalFS) Write(path string, fp io.ReadCloser) (int64, error) {
+// removePartial deletes the partial file of path, which goes along with it
+// even if the download never finished.
+func (l *LocalFS) removePartial(path string) error {
+	if err := os.Remove(lib.PartialPath(l.toLocalPath(path))); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete partial file")
+	}
+
+	return nil
+}
+
+// moveToTrash moves path into a directory in the trash named after the
+// current time, keeping its path relative to the root.
+func (l *LocalFS) moveToTrash(path string) error {
+	localPath := l.toLocalPath(path)
+
+	trashPath, err := uniquePath(filepath.Join(l.trashDir, l.now().UTC().Format(trashLayout), strings.TrimLeft(path, "/")))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(trashPath), l.dirMode); err != nil {
+		return errors.Wrap(err, "failed to create trash directory")
+	}
+
+	err = l.rename(localPath, trashPath)
+	if errors.Is(err, syscall.EXDEV) {
+		// the trash is on another filesystem
+		err = moveAcross(localPath, trashPath)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to move file to trash")
+	}
+
+	l.logger.WithField("path", path).WithField("trash", trashPath).Info("moved file to trash")
+	return nil
+}
+
+// moveAcross moves from to to by copying it, for when they're on different
+// filesystems and can't be renamed.
+func moveAcross(from, to string) error {
+	info, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(from)
+		if err != nil {
+			return err
+		}
+		if err = os.Symlink(target, to); err != nil {
+			return err
+		}
+		return os.Remove(from)
+	}
+
+	if err = copyFile(from, to, info); err != nil {
+		os.Remove(to)
+		return err
+	}
+
+	return os.Remove(from)
+}
+
+func copyFile(from, to string, info fs.FileInfo) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
+
+// uniquePath returns path, or path with a number before its extension if
+// something is already there, so a file deleted twice in the same second
+// doesn't replace the first copy in the trash.
+func uniquePath(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	candidate := path
+	for idx := 1; ; idx++ {
+		_, err := os.Lstat(candidate)
+		if os.IsNotExist(err) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "failed to check trash")
+		}
+
+		candidate = fmt.Sprintf("%s.%d%s", base, idx, ext)
+	}
+}
+
+// purgeTrash removes everything that was moved to the trash longer than the
+// trash retention ago.
+func (l *LocalFS) purgeTrash() error {
+	if l.trashDir == "" || l.trashRetention == 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(l.trashDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to read trash")
+	}
+
+	for _, entry := range entries {
+		deletedAt, err := time.Parse(trashLayout, entry.Name())
+		if err != nil || !entry.IsDir() {
+			// not ours
+			continue
+		}
+
+		if l.now().Sub(deletedAt) < l.trashRetention {
+			continue
+		}
+
+		if err = os.RemoveAll(filepath.Join(l.trashDir, entry.Name())); err != nil {
+			return errors.Wrapf(err, "failed to purge %s from trash", entry.Name())
+		}
+	}
+
+	return nil
+}
+
+func (l *LocalFS) Write(path string, offset int64, fp io.ReadCloser) (int64, error) {
 	var err error
 	path = l.toLocalPath(path)
 	dirname := filepath.Dir(path)
@@ -126,37 +506,55 @@ func (l *LocalFS) Write(path string, fp io.ReadCloser) (int64, error) {
 		}
 	}
 
-	temppath, err := os.CreateTemp(dirname, "temp")
+	partial, err := os.OpenFile(lib.PartialPath(path), os.O_CREATE|os.O_WRONLY, l.fileMode)
 	if err != nil {
-		return 0, errors.Wrap(err, "failed to create temp file")
+		return 0, errors.Wrap(err, "failed to open partial file")
 	}
 
-	size, err := io.Copy(temppath, fp)
+	// anything past offset was never confirmed by the caller, so drop it
+	if err = partial.Truncate(offset); err != nil {
+		l.safelyClose(partial)
+		return 0, errors.Wrap(err, "failed to truncate partial file")
+	}
+	if _, err = partial.Seek(offset, io.SeekStart); err != nil {
+		l.safelyClose(partial)
+		return 0, errors.Wrap(err, "failed to seek partial file")
+	}
+
+	size, err := io.Copy(partial, fp)
 	if err != nil {
-		l.safelyClose(temppath)
-		l.safelyRemove(temppath.Name())
+		l.safelyClose(partial)
 		return size, fmt.Errorf("failed to write file to disk (wrote %d bytes): %w", size, err)
 	}
 
-	if err = temppath.Close(); err != nil {
-		return 0, errors.Wrap(err, "failed to close temp file")
+	if err = partial.Close(); err != nil {
+		return 0, errors.Wrap(err, "failed to close partial file")
 	}
 
-	if err = os.Rename(temppath.Name(), path); err != nil {
-		return 0, errors.Wrap(err, "failed to rename temp file to final destination")
+	if err = os.Rename(partial.Name(), path); err != nil {
+		return 0, errors.Wrap(err, "failed to rename partial file to final destination")
 	}
 
-	if err = os.Chmod(path, l.fileMode); err != nil {
-		return 0, errors.Wrap(err, "failed to set the mode")
+	if err = l.setFileMode(path); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// setFileMode gives a new file the configured mode and owner.
+func (l *LocalFS) setFileMode(path string) error {
+	if err := os.Chmod(path, l.fileMode); err != nil {
+		return errors.Wrap(err, "failed to set the mode")
 	}
 
 	if l.fileUserID != 0 && l.fileGroupID != 0 {
-		if err = os.Chown(path, int(l.fileUserID), int(l.fileGroupID)); err != nil {
-			return 0, errors.Wrap(err, "failed to set file owner")
+		if err := os.Chown(path, int(l.fileUserID), int(l.fileGroupID)); err != nil {
+			return errors.Wrap(err, "failed to set file owner")
 		}
 	}
 
-	return size, nil
+	return nil
 }
 
 func (l *LocalFS) cleanDirectories(path string) (isDeleted bool, err error) {
@@ -177,6 +575,11 @@ func (l *LocalFS) cleanDirectories(path string) (isDeleted bool, err error) {
 	for _, entry := range entries {
 		if entry.IsDir() {
 			dirPath := filepath.Join(path, entry.Name())
+			if l.isTrash(dirPath) {
+				hasChildren = true
+				continue
+			}
+
 			wasDeleted, err = l.cleanDirectories(dirPath)
 			if err != nil {
 				return false, errors.Wrapf(err, "failed to clean %s", dirPath)
@@ -200,7 +603,13 @@ func (l *LocalFS) cleanDirectories(path string) (isDeleted bool, err error) {
 	return true, nil
 }
 
+// CleanDirectories removes empty directories under path, and purges the
+// trash.
 func (l *LocalFS) CleanDirectories(path string) error {
+	if err := l.purgeTrash(); err != nil {
+		return err
+	}
+
 	path = l.toLocalPath(path)
 
 	_, err := l.cleanDirectories(path)
@@ -208,20 +617,11 @@ func (l *LocalFS) CleanDirectories(path string) error {
 	return err
 }
 
-func (l *LocalFS) safelyClose(temppath *os.File) {
-	if err := temppath.Close(); err != nil {
-		l.logger.
-			WithField("path", temppath.Name()).
-			WithError(err).
-			Error("failed to close temp file")
-	}
-}
-
-func (l *LocalFS) safelyRemove(path string) {
-	if err := os.Remove(path); err != nil {
+func (l *LocalFS) safelyClose(fp *os.File) {
+	if err := fp.Close(); err != nil {
 		l.logger.
-			WithField("path", path).
+			WithField("path", fp.Name()).
 			WithError(err).
-			Error("failed to remove file")
+			Error("failed to close file")
 	}
 }
@@ -1,9 +1,22 @@
 package localfs
 
 import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/djeebus/ftpsync/lib"
+	"github.com/djeebus/ftpsync/lib/config"
 )
 
 func TestIgnoreNoSuchFile(t *testing.T) {
@@ -12,3 +25,274 @@ func TestIgnoreNoSuchFile(t *testing.T) {
 	_, err := d.cleanDirectories("/a/b/c/d")
 	require.NoError(t, err)
 }
+
+type failingReader struct {
+	data []byte
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func (f *failingReader) Close() error {
+	return nil
+}
+
+func TestResumeWrite(t *testing.T) {
+	root := t.TempDir()
+	d, err := New(config.Config{Destination: root, DirMode: 0o755, FileMode: 0o644}, nil, logrus.New())
+	require.NoError(t, err)
+
+	_, err = d.Write("/a/b.txt", 0, &failingReader{data: []byte("hello ")})
+	require.Error(t, err)
+
+	files, err := d.GetAllFiles("/")
+	require.NoError(t, err)
+	assert.Equal(t, 0, files.Len())
+
+	offset, err := d.GetPartialSize("/a/b.txt")
+	require.NoError(t, err)
+	require.Equal(t, int64(6), offset)
+
+	size, err := d.Write("/a/b.txt", offset, io.NopCloser(strings.NewReader("world")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	data, err := os.ReadFile(filepath.Join(root, "a", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+
+	offset, err = d.GetPartialSize("/a/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+}
+
+func TestDeletePartial(t *testing.T) {
+	root := t.TempDir()
+	d, err := New(config.Config{Destination: root, DirMode: 0o755, FileMode: 0o644}, nil, logrus.New())
+	require.NoError(t, err)
+
+	_, err = d.Write("/a/b.txt", 0, &failingReader{data: []byte("hello ")})
+	require.Error(t, err)
+
+	// the file was never finished, but the partial still goes
+	assert.Error(t, d.Delete("/a/b.txt"))
+
+	offset, err := d.GetPartialSize("/a/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), offset)
+	assert.NoFileExists(t, filepath.Join(root, "a", lib.PartialPath("b.txt")))
+}
+
+func TestRead(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b.txt"), []byte("hello world"), 0o644))
+
+	d, err := New(config.Config{Destination: root}, nil, logrus.New())
+	require.NoError(t, err)
+
+	fp, err := d.Read("/a/b.txt", 6)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	data, err := io.ReadAll(fp)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	_, err = d.Read("/a/missing.txt", 0)
+	assert.Error(t, err)
+}
+
+func TestTrash(t *testing.T) {
+	root := t.TempDir()
+	trash := filepath.Join(root, ".trash")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b.txt"), []byte("hello"), 0o644))
+
+	d, err := New(config.Config{
+		Destination:    root,
+		DirMode:        0o755,
+		TrashDir:       trash,
+		TrashRetention: config.Period(24 * time.Hour),
+	}, nil, logrus.New())
+	require.NoError(t, err)
+
+	now := time.Date(2023, 5, 31, 12, 34, 56, 0, time.UTC)
+	d.now = func() time.Time { return now }
+
+	require.NoError(t, d.Delete("/a/b.txt"))
+
+	data, err := os.ReadFile(filepath.Join(trash, "20230531T123456Z", "a", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// deleting it again in the same second keeps both copies
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b.txt"), []byte("world"), 0o644))
+	require.NoError(t, d.Delete("/a/b.txt"))
+
+	data, err = os.ReadFile(filepath.Join(trash, "20230531T123456Z", "a", "b.1.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	// the trash isn't part of the destination
+	files, err := d.GetAllFiles("/")
+	require.NoError(t, err)
+	assert.Equal(t, 0, files.Len())
+
+	require.NoError(t, d.CleanDirectories("/"))
+	assert.DirExists(t, filepath.Join(trash, "20230531T123456Z"))
+	assert.NoDirExists(t, filepath.Join(root, "a"))
+
+	now = now.Add(24 * time.Hour)
+	require.NoError(t, d.CleanDirectories("/"))
+	assert.NoDirExists(t, filepath.Join(trash, "20230531T123456Z"))
+}
+
+func TestTrashOnAnotherFilesystem(t *testing.T) {
+	root := t.TempDir()
+	trash := filepath.Join(root, ".trash")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b.txt"), []byte("hello"), 0o640))
+
+	d, err := New(config.Config{Destination: root, DirMode: 0o755, TrashDir: trash}, nil, logrus.New())
+	require.NoError(t, err)
+
+	now := time.Date(2023, 5, 31, 12, 34, 56, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	d.rename = func(from, to string) error {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: syscall.EXDEV}
+	}
+
+	require.NoError(t, d.Delete("/a/b.txt"))
+	assert.NoFileExists(t, filepath.Join(root, "a", "b.txt"))
+
+	trashed := filepath.Join(trash, "20230531T123456Z", "a", "b.txt")
+	data, err := os.ReadFile(trashed)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	info, err := os.Stat(trashed)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+}
+
+func TestDiscardSkipsTrash(t *testing.T) {
+	root := t.TempDir()
+	trash := filepath.Join(root, ".trash")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b.txt"), []byte("hel"), 0o644))
+
+	d, err := New(config.Config{Destination: root, DirMode: 0o755, TrashDir: trash}, nil, logrus.New())
+	require.NoError(t, err)
+
+	// broken downloads aren't anyone's data
+	require.NoError(t, d.Discard("/a/b.txt"))
+	assert.NoFileExists(t, filepath.Join(root, "a", "b.txt"))
+	assert.NoDirExists(t, trash)
+}
+
+func TestGetAllFilesFilters(t *testing.T) {
+	root := t.TempDir()
+	for _, path := range []string{"movies/a.mkv", "movies/a.nfo", "movies/.cache/b.mkv", "shows/c.mkv"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, path), []byte("data"), 0o644))
+	}
+
+	filter, err := lib.NewFilter(lib.FilterOptions{Exclude: []string{"*.nfo"}, ExcludeHidden: true})
+	require.NoError(t, err)
+
+	d, err := New(config.Config{Destination: root}, filter, logrus.New())
+	require.NoError(t, err)
+
+	files, err := d.GetAllFiles("/movies")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/movies/a.mkv"}, files.ToSet().ToList())
+}
+
+func TestGetAllFilesLinks(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "movies", "complete"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "movies", "complete", "a.mkv"), []byte("data"), 0o644))
+	require.NoError(t, os.Symlink("complete/a.mkv", filepath.Join(root, "movies", "latest.mkv")))
+	require.NoError(t, os.Symlink("complete", filepath.Join(root, "movies", "sorted")))
+	require.NoError(t, os.Symlink("..", filepath.Join(root, "movies", "complete", "up")))
+
+	files := func(links string) map[string]lib.FileInfo {
+		d, err := New(config.Config{Destination: root, Links: links}, nil, logrus.New())
+		require.NoError(t, err)
+
+		set, err := d.GetAllFiles("/movies")
+		require.NoError(t, err)
+
+		result := make(map[string]lib.FileInfo)
+		for _, path := range set.ToSet().ToList() {
+			info, _ := set.GetInfo(path)
+			info.ModTime = time.Time{}
+			result[path] = info
+		}
+		return result
+	}
+
+	assert.Equal(t, map[string]lib.FileInfo{
+		"/movies/complete/a.mkv": {Size: 4},
+	}, files(config.LinksSkip))
+
+	assert.Equal(t, map[string]lib.FileInfo{
+		"/movies/complete/a.mkv": {Size: 4},
+		"/movies/latest.mkv":     {Size: 4},
+		"/movies/sorted/a.mkv":   {Size: 4},
+	}, files(config.LinksFollow))
+
+	assert.Equal(t, map[string]lib.FileInfo{
+		"/movies/complete/a.mkv": {Size: 4},
+		"/movies/complete/up":    {Link: ".."},
+		"/movies/latest.mkv":     {Link: "complete/a.mkv"},
+		"/movies/sorted":         {Link: "complete"},
+	}, files(config.LinksRecreate))
+}
+
+func TestSymlink(t *testing.T) {
+	root := t.TempDir()
+
+	d, err := New(config.Config{Destination: root, DirMode: 0o755}, nil, logrus.New())
+	require.NoError(t, err)
+
+	require.NoError(t, d.Symlink("/movies/latest.mkv", "complete/a.mkv"))
+
+	target, err := os.Readlink(filepath.Join(root, "movies", "latest.mkv"))
+	require.NoError(t, err)
+	assert.Equal(t, "complete/a.mkv", target)
+}
+
+func TestExtractMode(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a"), 0o755))
+
+	fp, err := os.Create(filepath.Join(root, "a", "b.zip"))
+	require.NoError(t, err)
+	archive := zip.NewWriter(fp)
+	w, err := archive.Create("c/d.txt")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, archive.Close())
+	require.NoError(t, fp.Close())
+
+	d, err := New(config.Config{Destination: root, DirMode: 0o755, FileMode: 0o666}, nil, logrus.New())
+	require.NoError(t, err)
+
+	files, err := d.Extract("/a/b.zip")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/a/c/d.txt"}, files)
+
+	// the umask doesn't get a say, just like with downloads
+	info, err := os.Stat(filepath.Join(root, "a", "c", "d.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o666), info.Mode().Perm())
+}
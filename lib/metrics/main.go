@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const namespace = "ftpsync"
+
+// Registry holds the metrics for every job in the process.
+type Registry struct {
+	registry *prometheus.Registry
+
+	downloadedBytes *prometheus.CounterVec
+	actions         *prometheus.CounterVec
+	failures        *prometheus.CounterVec
+	lastSuccess     *prometheus.GaugeVec
+	duration        *prometheus.HistogramVec
+	files           *prometheus.GaugeVec
+	deletesBlocked  *prometheus.CounterVec
+}
+
+func New() *Registry {
+	r := &Registry{
+		registry: prometheus.NewRegistry(),
+
+		downloadedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes written to the destination.",
+		}, []string{"job"}),
+		actions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "actions_total",
+			Help:      "Actions run on files, by action name.",
+		}, []string{"job", "action"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "action_failures_total",
+			Help:      "Actions that failed, by action name.",
+		}, []string{"job", "action"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "last_success_timestamp_seconds",
+			Help:      "When the last successful sync finished.",
+		}, []string{"job"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "sync_duration_seconds",
+			Help:      "How long each sync took.",
+			// one second to a bit over two hours
+			Buckets: prometheus.ExponentialBuckets(1, 2, 14),
+		}, []string{"job"}),
+		files: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "files",
+			Help:      "Files found by the last sync, by where they were found.",
+		}, []string{"job", "location"}),
+		deletesBlocked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "deletes_blocked_total",
+			Help:      "Syncs that were stopped because they would have deleted too many files.",
+		}, []string{"job"}),
+	}
+
+	r.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		r.downloadedBytes,
+		r.actions,
+		r.failures,
+		r.lastSuccess,
+		r.duration,
+		r.files,
+		r.deletesBlocked,
+	)
+
+	return r
+}
+
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+}
+
+// ForJob returns the metrics for a single job.
+func (r *Registry) ForJob(name string) lib.Metrics {
+	return &jobMetrics{r, name}
+}
+
+type jobMetrics struct {
+	*Registry
+	job string
+}
+
+var _ lib.Metrics = new(jobMetrics)
+
+func (m *jobMetrics) PlanCreated(plan *lib.Plan) {
+	m.files.WithLabelValues(m.job, "remote").Set(float64(plan.RemoteCount))
+	m.files.WithLabelValues(m.job, "recorded").Set(float64(plan.RecordedCount))
+	m.files.WithLabelValues(m.job, "local").Set(float64(plan.LocalCount))
+}
+
+func (m *jobMetrics) ActionFinished(action string, err error) {
+	m.actions.WithLabelValues(m.job, action).Inc()
+	if err != nil {
+		m.failures.WithLabelValues(m.job, action).Inc()
+	}
+}
+
+func (m *jobMetrics) Downloaded(bytes int64) {
+	m.downloadedBytes.WithLabelValues(m.job).Add(float64(bytes))
+}
+
+func (m *jobMetrics) DeletesBlocked(int) {
+	m.deletesBlocked.WithLabelValues(m.job).Inc()
+}
+
+func (m *jobMetrics) SyncFinished(duration time.Duration, err error) {
+	m.duration.WithLabelValues(m.job).Observe(duration.Seconds())
+	if err == nil {
+		m.lastSuccess.WithLabelValues(m.job).SetToCurrentTime()
+	}
+}
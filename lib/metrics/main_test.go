@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+func TestJobMetrics(t *testing.T) {
+	registry := New()
+	movies := registry.ForJob("movies")
+	music := registry.ForJob("music")
+
+	movies.PlanCreated(&lib.Plan{RemoteCount: 3, RecordedCount: 2, LocalCount: 1})
+	movies.ActionFinished("download", nil)
+	movies.ActionFinished("download", errors.New("oops"))
+	movies.ActionFinished("skip", nil)
+	movies.Downloaded(100)
+	movies.Downloaded(50)
+	movies.SyncFinished(time.Second, nil)
+	music.DeletesBlocked(10)
+	music.SyncFinished(time.Second, errors.New("oops"))
+
+	assert.Equal(t, 150.0, testutil.ToFloat64(registry.downloadedBytes.WithLabelValues("movies")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(registry.actions.WithLabelValues("movies", "download")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(registry.failures.WithLabelValues("movies", "download")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(registry.failures.WithLabelValues("movies", "skip")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(registry.files.WithLabelValues("movies", "remote")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(registry.files.WithLabelValues("movies", "local")))
+	assert.InDelta(t, float64(time.Now().Unix()), testutil.ToFloat64(registry.lastSuccess.WithLabelValues("movies")), 5)
+	assert.Equal(t, 0.0, testutil.ToFloat64(registry.lastSuccess.WithLabelValues("music")))
+	assert.Equal(t, 2, testutil.CollectAndCount(registry.duration))
+	assert.Equal(t, 1.0, testutil.ToFloat64(registry.deletesBlocked.WithLabelValues("music")))
+
+	server := httptest.NewServer(registry.Handler())
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL)
+	require.NoError(t, err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `ftpsync_downloaded_bytes_total{job="movies"} 150`)
+	assert.Contains(t, string(body), `ftpsync_files{job="movies",location="recorded"} 2`)
+}
@@ -0,0 +1,20 @@
+package lib
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// PartialSuffix marks files that are still being written, so an
+// interrupted transfer can be resumed on the next run.
+const PartialSuffix = ".ftpsync-partial"
+
+// PartialPath returns where path is written to until it's complete.
+func PartialPath(path string) string {
+	dirname, filename := filepath.Split(path)
+	return filepath.Join(dirname, "."+filename+PartialSuffix)
+}
+
+func IsPartialPath(path string) bool {
+	return strings.HasSuffix(path, PartialSuffix)
+}
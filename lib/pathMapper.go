@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/pkg/errors"
+)
+
+// PathMapper renames files on their way from the source to the destination,
+// by running every rule in order on the path relative to the root. Rules
+// are:
+//
+//	flatten          drop every directory
+//	strip=1          drop that many leading directories
+//	s/regex/repl/    replace with a regex, with any delimiter after the s
+//	*.mkv=video/     move matching files into a folder, using the same
+//	                 globs as filters
+type PathMapper struct {
+	rules []pathRule
+}
+
+type pathRule func(rel string) string
+
+func NewPathMapper(rules []string) (*PathMapper, error) {
+	m := new(PathMapper)
+
+	for _, text := range rules {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		rule, err := parsePathRule(text)
+		if err != nil {
+			return nil, err
+		}
+
+		m.rules = append(m.rules, rule)
+	}
+
+	return m, nil
+}
+
+func parsePathRule(text string) (pathRule, error) {
+	if text == "flatten" {
+		return path.Base, nil
+	}
+
+	if count, ok := strings.CutPrefix(text, "strip="); ok {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid strip count: %q", text)
+		}
+		return stripRule(n), nil
+	}
+
+	if len(text) > 2 && text[0] == 's' && !isPathRuneOrGlob(text[1]) {
+		return parseRegexRule(text)
+	}
+
+	if pattern, dir, ok := strings.Cut(text, "="); ok {
+		pattern = strings.TrimLeft(pattern, "/")
+		if pattern == "" || !doublestar.ValidatePattern(pattern) {
+			return nil, fmt.Errorf("invalid pattern in rule: %q", text)
+		}
+		return sortRule(pattern, strings.Trim(dir, "/")), nil
+	}
+
+	return nil, fmt.Errorf("unknown path rule: %q", text)
+}
+
+// isPathRuneOrGlob reports whether c could continue a glob, so rules like
+// sample*=extras/ aren't mistaken for regexes.
+func isPathRuneOrGlob(c byte) bool {
+	return c == '*' || c == '?' || c == '[' || c == '{' || c == '.' || c == '-' || c == '_' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func parseRegexRule(text string) (pathRule, error) {
+	delimiter := text[1:2]
+
+	parts := strings.Split(text[2:], delimiter)
+	if len(parts) != 3 || parts[2] != "" {
+		return nil, fmt.Errorf("regex rules look like s/regex/replacement/: %q", text)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid regex in rule %q", text)
+	}
+
+	replacement := parts[1]
+	return func(rel string) string {
+		return re.ReplaceAllString(rel, replacement)
+	}, nil
+}
+
+func stripRule(count int) pathRule {
+	return func(rel string) string {
+		parts := strings.Split(rel, "/")
+		if count >= len(parts) {
+			// always keep the file name
+			return parts[len(parts)-1]
+		}
+		return strings.Join(parts[count:], "/")
+	}
+}
+
+func sortRule(pattern, dir string) pathRule {
+	patterns := []string{pattern}
+	return func(rel string) string {
+		if !matchAny(patterns, rel) {
+			return rel
+		}
+		return path.Join(dir, rel)
+	}
+}
+
+// Map returns where the file at key, under rootPath on the source, goes on
+// the destination. Rules can't move files out of rootPath.
+func (m *PathMapper) Map(rootPath, key string) string {
+	if m == nil || len(m.rules) == 0 {
+		return key
+	}
+
+	rel := strings.TrimLeft(relativePath(rootPath, key), "/")
+	for _, rule := range m.rules {
+		rel = rule(rel)
+	}
+
+	// joining to / drops any ../ that would escape the root
+	rel = path.Join("/", rel)
+	if rel == "/" {
+		return key
+	}
+
+	return path.Join(rootPath, rel)
+}
+
+// mapLocalFiles works out where every file lives on the destination, and
+// rekeys localFiles by the source path, so the rest of the plan can compare
+// them. Files that were removed from the source keep the path they were
+// recorded with. It also returns the files that would overwrite another.
+func (p *Processor) mapLocalFiles(rootPath string, remoteFiles *SizeSet, localFiles *SizeSet) (*SizeSet, map[string]string, map[string]string, error) {
+	recorded, err := p.db.GetLocalPaths(rootPath)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to get recorded local paths")
+	}
+
+	localPaths := make(map[string]string, len(recorded)+remoteFiles.Len())
+	for key, localPath := range recorded {
+		localPaths[key] = localPath
+	}
+
+	remoteKeys := remoteFiles.ToSet().ToList()
+	sort.Strings(remoteKeys)
+
+	owners := make(map[string]string)
+	collisions := make(map[string]string)
+	for _, key := range remoteKeys {
+		localPath := p.pathMapper.Map(rootPath, key)
+		if owner, ok := owners[localPath]; ok {
+			collisions[key] = owner
+			continue
+		}
+
+		owners[localPath] = key
+		localPaths[key] = localPath
+	}
+
+	keys := make(map[string]string, len(localPaths))
+	for key, localPath := range localPaths {
+		keys[localPath] = key
+	}
+
+	mapped := NewSizeSet()
+	for localPath, info := range localFiles.m {
+		key, ok := keys[localPath]
+		if !ok {
+			if _, isKey := localPaths[localPath]; isKey {
+				p.log.WithField("file", localPath).Warning("local file is in the way of a renamed file, ignoring it")
+				continue
+			}
+			key = localPath
+		}
+
+		mapped.SetInfo(key, info)
+	}
+
+	return mapped, localPaths, collisions, nil
+}
+
+// renamedPath returns where key goes on the destination, or nothing if it
+// isn't renamed.
+func renamedPath(localPaths map[string]string, key string) string {
+	if localPath, ok := localPaths[key]; ok && localPath != key {
+		return localPath
+	}
+
+	return ""
+}
+
+// localPath returns where the file at key is on the destination.
+func (p *Processor) localPath(key string) string {
+	if localPath, ok := p.localPaths[key]; ok {
+		return localPath
+	}
+
+	return key
+}
+
+// recordLocalPath remembers where key was put, so it can still be found
+// once it's removed from the source.
+func (p *Processor) recordLocalPath(key string) error {
+	if p.pathMapper == nil {
+		return nil
+	}
+
+	if err := p.db.RecordLocalPath(key, p.localPath(key)); err != nil {
+		return errors.Wrapf(err, "failed to record local path of %s", key)
+	}
+
+	return nil
+}
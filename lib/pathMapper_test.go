@@ -0,0 +1,76 @@
+package lib
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathMapper(t *testing.T) {
+	testCases := []struct {
+		rules    []string
+		key      string
+		expected string
+	}{
+		{nil, "/root/a/b.mkv", "/root/a/b.mkv"},
+		{[]string{"flatten"}, "/root/a/b.mkv", "/root/b.mkv"},
+		{[]string{"strip=1"}, "/root/a/b/c.mkv", "/root/b/c.mkv"},
+		{[]string{"strip=5"}, "/root/a/b/c.mkv", "/root/c.mkv"},
+		{[]string{`s/\.(\d+)p\././`}, "/root/a.1080p.mkv", "/root/a.mkv"},
+		{[]string{`s|^([^/]+)/|$1 - |`}, "/root/show/ep.mkv", "/root/show - ep.mkv"},
+		{[]string{"*.mkv=video/"}, "/root/a/b.mkv", "/root/video/a/b.mkv"},
+		{[]string{"*.mkv=video/"}, "/root/a/b.srt", "/root/a/b.srt"},
+		{[]string{"flatten", "*.mkv=/video/"}, "/root/a/b.mkv", "/root/video/b.mkv"},
+		{[]string{`s/.*//`}, "/root/a.mkv", "/root/a.mkv"},
+		{[]string{`s|^|../../|`}, "/root/a.mkv", "/root/a.mkv"},
+	}
+
+	for _, tc := range testCases {
+		mapper, err := NewPathMapper(tc.rules)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, mapper.Map("/root", tc.key), "%v", tc.rules)
+	}
+
+	for _, rule := range []string{"strip=0", "strip=x", "s/(/x/", "s/a/b", "nonsense", "[a-=video"} {
+		_, err := NewPathMapper([]string{rule})
+		assert.Error(t, err, rule)
+	}
+}
+
+func TestProcessRenamesFiles(t *testing.T) {
+	src := newMemSource(map[string]string{"/a/x.mkv": "x", "/b/x.mkv": "other x", "/a/y.txt": "y"})
+	dst := newMemDestination(map[string]string{})
+	db := newMemDatabase()
+
+	mapper, err := NewPathMapper([]string{"flatten", "*.mkv=video/"})
+	require.NoError(t, err)
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithPathMapper(mapper))
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+
+	var actions []string
+	for _, action := range plan.Actions {
+		actions = append(actions, fmt.Sprintf("%s %s %s %s", action.Action.Name, action.Path, action.LocalPath, action.Reason))
+	}
+	assert.Equal(t, []string{
+		"download /a/x.mkv /video/x.mkv ",
+		"download /a/y.txt /y.txt ",
+		"log /b/x.mkv  renamed to the same path as /a/x.mkv",
+	}, actions)
+
+	require.NoError(t, p.Execute(plan))
+	assert.Equal(t, map[string]string{"/video/x.mkv": "x", "/y.txt": "y"}, dst.contents())
+	assert.Equal(t, map[string]string{"/a/x.mkv": "/video/x.mkv", "/a/y.txt": "/y.txt"}, db.locals)
+
+	plan, err = p.Plan("/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"skip": 2, "log": 1}, plan.Counts())
+
+	// the recorded path is used once the file is gone from the source
+	delete(src.files, "/a/y.txt")
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{"/video/x.mkv": "x"}, dst.contents())
+}
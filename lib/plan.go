@@ -0,0 +1,322 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// PlannedAction is what Execute will do to a single file.
+type PlannedAction struct {
+	Path   string        `json:"path"`
+	State  FileStatusKey `json:"state"`
+	Action NamedAction   `json:"action"`
+
+	// ReplacesLocal is set when the local copy has to be deleted before the
+	// action runs, and Reason says why.
+	ReplacesLocal bool   `json:"replaces_local,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+
+	RemoteSize int64 `json:"remote_size,omitempty"`
+	LocalSize  int64 `json:"local_size,omitempty"`
+
+	// LocalPath is set when the file is renamed on the destination.
+	LocalPath string `json:"local_path,omitempty"`
+
+	// RemoteChanged and LocalChanged are only set by two-way sync.
+	RemoteChanged bool `json:"remote_changed,omitempty"`
+	LocalChanged  bool `json:"local_changed,omitempty"`
+}
+
+type Plan struct {
+	Root    string          `json:"root"`
+	Actions []PlannedAction `json:"actions"`
+
+	RemoteCount   int `json:"remote_count"`
+	RecordedCount int `json:"recorded_count"`
+	LocalCount    int `json:"local_count"`
+
+	// Blocked says why Execute will refuse to run the plan, if it will.
+	Blocked string `json:"blocked,omitempty"`
+
+	remoteFiles *SizeSet
+	localFiles  *SizeSet
+	localPaths  map[string]string
+}
+
+func (a NamedAction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Name)
+}
+
+// Plan compares the source, database and destination under rootPath and
+// decides what to do with every file, without changing any of them.
+func (p *Processor) Plan(rootPath string) (*Plan, error) {
+	var (
+		err error
+
+		remoteFiles *SizeSet
+		dbFiles     *Set
+		localFiles  *SizeSet
+	)
+
+	if p.twoWay && p.pathMapper != nil {
+		return nil, errors.New("two-way sync can't rename files")
+	}
+
+	if p.twoWay {
+		if _, ok := p.remote.(Destination); !ok {
+			return nil, errors.New("two-way sync needs a source that can be written to")
+		}
+		if _, ok := p.local.(Source); !ok {
+			return nil, errors.New("two-way sync needs a destination that can be read from")
+		}
+	}
+
+	if remoteFiles, err = p.remote.GetAllFiles(rootPath); err != nil {
+		return nil, errors.Wrap(err, "failed to get ftp files")
+	}
+	p.log.WithField("count", remoteFiles.Len()).Info("found remote files")
+
+	if dbFiles, err = p.db.GetAllFiles(rootPath); err != nil {
+		return nil, errors.Wrap(err, "failed to get database files")
+	}
+	p.log.WithField("count", dbFiles.Len()).Info("found recorded files")
+
+	if localFiles, err = p.local.GetAllFiles(rootPath); err != nil {
+		return nil, errors.Wrap(err, "failed to get local files")
+	}
+	p.log.WithField("count", localFiles.Len()).Info("found local files")
+
+	var (
+		localPaths map[string]string
+		collisions map[string]string
+	)
+	if p.pathMapper != nil {
+		if localFiles, localPaths, collisions, err = p.mapLocalFiles(rootPath, remoteFiles, localFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	var extracted map[string]string
+	if p.extract && !p.twoWay {
+		if extracted, err = p.db.GetExtractedFiles(rootPath); err != nil {
+			return nil, errors.Wrap(err, "failed to get extracted files")
+		}
+	}
+
+	allFiles := NewSet().Union(remoteFiles.ToSet()).Union(dbFiles).Union(localFiles.ToSet())
+	p.log.WithField("count", allFiles.Len()).Info("total files found")
+
+	plan := &Plan{
+		Root:          rootPath,
+		RemoteCount:   remoteFiles.Len(),
+		RecordedCount: dbFiles.Len(),
+		LocalCount:    localFiles.Len(),
+		remoteFiles:   remoteFiles,
+		localFiles:    localFiles,
+		localPaths:    localPaths,
+	}
+
+	paths := allFiles.ToList()
+	sort.Strings(paths)
+
+	for _, file := range paths {
+		if p.twoWay {
+			plan.Actions = append(plan.Actions, p.planTwoWay(file, remoteFiles, dbFiles, localFiles))
+			continue
+		}
+
+		log := p.log.WithField("file", file)
+		hasDbFile := dbFiles.Has(file)
+		localInfo, hasLocalFile := localFiles.GetInfo(file)
+		localSize := localInfo.Size
+		remoteInfo, hasRemoteFile := remoteFiles.GetInfo(file)
+		remoteSize := remoteInfo.Size
+
+		if other, ok := collisions[file]; ok {
+			plan.Actions = append(plan.Actions, PlannedAction{
+				Path:       file,
+				State:      FileStatusKey{HasRemote: true, IsRecorded: hasDbFile, HasLocal: hasLocalFile},
+				Action:     NamedAction{logFile, "log"},
+				Reason:     fmt.Sprintf("renamed to the same path as %s", other),
+				RemoteSize: remoteSize,
+			})
+			continue
+		}
+
+		if !hasRemoteFile && !hasDbFile && hasLocalFile {
+			if action, ok := p.planExtracted(file, extracted, remoteFiles, dbFiles); ok {
+				action.LocalSize = localSize
+				plan.Actions = append(plan.Actions, action)
+				continue
+			}
+		}
+
+		var (
+			reason     string
+			recordOnly bool
+		)
+		if hasLocalFile && hasRemoteFile && localInfo.Link != remoteInfo.Link {
+			reason = "link changed"
+		} else if hasLocalFile && hasRemoteFile && localSize != remoteSize {
+			reason = "size mismatch"
+		} else if hasLocalFile && hasRemoteFile && hasDbFile && !remoteInfo.ModTime.IsZero() {
+			if recorded, err := p.db.GetModTime(file); err != nil {
+				log.WithError(err).Error("failed to get recorded modification time")
+			} else if recorded.IsZero() {
+				// recorded before modification times were, so trust the
+				// local copy and fill it in
+				recordOnly = true
+			} else if !recorded.Equal(remoteInfo.ModTime) {
+				reason = "remote changed"
+			}
+		}
+
+		if reason == "" && hasLocalFile && hasDbFile && p.verifyLocal {
+			if isCorrupt, err := p.isLocalCorrupt(file); err != nil {
+				log.WithError(err).Error("failed to verify local file")
+			} else if isCorrupt {
+				reason = "checksum mismatch"
+			}
+		}
+
+		if p.keepRemoved && hasDbFile {
+			key := FileStatusKey{HasRemote: hasRemoteFile, IsRecorded: true, HasLocal: hasLocalFile}
+			if action, reason, ok := p.planRetention(file, key); ok {
+				plan.Actions = append(plan.Actions, PlannedAction{
+					Path:       file,
+					State:      key,
+					Action:     action,
+					Reason:     reason,
+					LocalPath:  renamedPath(localPaths, file),
+					RemoteSize: remoteSize,
+					LocalSize:  localSize,
+				})
+				continue
+			}
+		}
+
+		key := FileStatusKey{
+			IsRecorded: hasDbFile,
+			HasRemote:  hasRemoteFile,
+			HasLocal:   hasLocalFile && reason == "",
+		}
+
+		action := fileStatusActions[key]
+		if recordOnly && reason == "" {
+			action = NamedAction{recordFile, "record"}
+			reason = "missing modification time"
+		}
+
+		plan.Actions = append(plan.Actions, PlannedAction{
+			Path:          file,
+			State:         key,
+			Action:        action,
+			ReplacesLocal: !key.HasLocal && hasLocalFile,
+			Reason:        reason,
+			LocalPath:     renamedPath(localPaths, file),
+			RemoteSize:    remoteSize,
+			LocalSize:     localSize,
+		})
+	}
+
+	if _, err := p.deletionLimitError(plan); err != nil {
+		plan.Blocked = err.Error()
+	}
+
+	return plan, nil
+}
+
+// Counts returns how many files each action will be applied to.
+func (plan *Plan) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, action := range plan.Actions {
+		counts[action.Action.Name]++
+	}
+	return counts
+}
+
+func (plan *Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(plan); err != nil {
+		return errors.Wrap(err, "failed to encode plan")
+	}
+
+	return nil
+}
+
+// WriteText writes a table of every file that is out of sync, followed by
+// a summary of all actions.
+func (plan *Plan) WriteText(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(table, "ACTION\tPATH\tREMOTE\tLOCAL\tSTATE\tREASON")
+	for _, action := range plan.Actions {
+		if action.Action.Name == "skip" {
+			continue
+		}
+
+		name := action.Action.Name
+		if action.ReplacesLocal {
+			name = "re" + name
+		}
+
+		path := action.Path
+		if action.LocalPath != "" {
+			path += " -> " + action.LocalPath
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			name,
+			path,
+			fmtPlanSize(action.State.HasRemote, action.RemoteSize),
+			fmtPlanSize(action.State.HasLocal || action.ReplacesLocal, action.LocalSize),
+			action.State.String(),
+			action.Reason,
+		)
+	}
+
+	if err := table.Flush(); err != nil {
+		return errors.Wrap(err, "failed to write plan")
+	}
+
+	counts := plan.Counts()
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	summary := make([]string, 0, len(names))
+	for _, name := range names {
+		summary = append(summary, fmt.Sprintf("%d %s", counts[name], name))
+	}
+
+	if _, err := fmt.Fprintf(w, "\n%d remote, %d recorded, %d local: %s\n",
+		plan.RemoteCount, plan.RecordedCount, plan.LocalCount, strings.Join(summary, ", "),
+	); err != nil {
+		return errors.Wrap(err, "failed to write plan")
+	}
+
+	if plan.Blocked != "" {
+		if _, err := fmt.Fprintf(w, "nothing will be done: %s\n", plan.Blocked); err != nil {
+			return errors.Wrap(err, "failed to write plan")
+		}
+	}
+
+	return nil
+}
+
+func fmtPlanSize(exists bool, size int64) string {
+	if !exists {
+		return "-"
+	}
+
+	return fmtSize(size)
+}
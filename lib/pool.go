@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+type workerPool struct {
+	jobs    chan PlannedAction
+	wg      sync.WaitGroup
+	sources []Source
+	log     logrus.FieldLogger
+}
+
+// startWorkers starts p.concurrency workers, each running actions through a
+// copy of p with its own source. It returns nil when downloads should run
+// inline instead, which they always do in two-way sync, as uploads share
+// the source.
+func (p *Processor) startWorkers() *workerPool {
+	if p.concurrency <= 1 || p.newSource == nil || p.twoWay {
+		return nil
+	}
+
+	pool := &workerPool{jobs: make(chan PlannedAction), log: p.log}
+
+	// the first worker reuses the source that produced the listing
+	first := *p
+	workers := []*Processor{&first}
+	for idx := 1; idx < p.concurrency; idx++ {
+		src, err := p.newSource()
+		if err != nil {
+			p.log.WithError(err).Warning("failed to open source for worker, continuing with fewer workers")
+			break
+		}
+		pool.sources = append(pool.sources, src)
+
+		worker := *p
+		worker.remote = src
+		workers = append(workers, &worker)
+	}
+
+	p.log.WithField("count", len(workers)).Info("starting download workers")
+
+	for idx, worker := range workers {
+		worker.log = p.log.WithField("worker", idx)
+
+		pool.wg.Add(1)
+		go func(worker *Processor) {
+			defer pool.wg.Done()
+			for action := range pool.jobs {
+				worker.runAction(action)
+			}
+		}(worker)
+	}
+
+	return pool
+}
+
+func (w *workerPool) submit(action PlannedAction) {
+	w.jobs <- action
+}
+
+// wait blocks until every submitted job has finished, then closes the
+// sources opened for the workers.
+func (w *workerPool) wait() {
+	close(w.jobs)
+	w.wg.Wait()
+
+	for _, src := range w.sources {
+		if err := src.Close(); err != nil {
+			w.log.WithError(err).Warning("failed to close worker source")
+		}
+	}
+}
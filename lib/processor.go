@@ -3,14 +3,135 @@ package lib
 import (
 	"fmt"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-func BuildProcessor(src Source, db Database, precheck Precheck, dst Destination, log logrus.FieldLogger) *Processor {
-	return &Processor{src, db, dst, precheck, log}
+// SourceFactory opens a new connection to the source, for use by a single
+// download worker.
+type SourceFactory func() (Source, error)
+
+type Option func(*Processor)
+
+// WithConcurrency downloads up to count files at once. Every worker beyond
+// the first gets its own source from newSource, as most sources can't be
+// shared between goroutines.
+func WithConcurrency(count int, newSource SourceFactory) Option {
+	return func(p *Processor) {
+		p.concurrency = count
+		p.newSource = newSource
+	}
+}
+
+// WithChecksumVerification hashes every download and compares it to the
+// source's checksum, when the source can provide one. The local checksum is
+// recorded either way.
+func WithChecksumVerification() Option {
+	return func(p *Processor) {
+		p.verifyChecksums = true
+	}
+}
+
+// WithLocalVerification re-hashes local files on every run, and downloads
+// them again if they no longer match the recorded checksum. It implies
+// WithChecksumVerification, which is what records the checksums.
+func WithLocalVerification() Option {
+	return func(p *Processor) {
+		p.verifyChecksums = true
+		p.verifyLocal = true
+	}
+}
+
+// WithPreservedModTimes sets the modification time of every download to
+// match the remote file, when the destination supports it.
+func WithPreservedModTimes() Option {
+	return func(p *Processor) {
+		p.preserveModTimes = true
+	}
+}
+
+// WithBandwidthLimit reads every download through limiter, which all of the
+// workers share.
+func WithBandwidthLimit(limiter *BandwidthLimiter) Option {
+	return func(p *Processor) {
+		p.bandwidth = limiter
+	}
+}
+
+// WithMetrics reports every sync to metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(p *Processor) {
+		p.metrics = metrics
+	}
+}
+
+// WithTwoWaySync copies changes in both directions, instead of mirroring
+// the source. The source has to be a Destination too, and the destination a
+// Source.
+func WithTwoWaySync() Option {
+	return func(p *Processor) {
+		p.twoWay = true
+	}
+}
+
+// WithRetention keeps the local copies of files that are removed from the
+// source, and records a tombstone so they aren't downloaded again. They are
+// deleted keepFor after they were removed, or never if keepFor is zero.
+func WithRetention(keepFor time.Duration) Option {
+	return func(p *Processor) {
+		p.keepRemoved = true
+		p.keepFor = keepFor
+	}
+}
+
+// WithDeletionLimit makes Execute fail before changing anything if the plan
+// deletes more than maxDeletes files, or more than maxDeletePercent of the
+// local files. Either limit can be zero to turn it off.
+func WithDeletionLimit(maxDeletes int, maxDeletePercent float64) Option {
+	return func(p *Processor) {
+		p.maxDeletes = maxDeletes
+		p.maxDeletePercent = maxDeletePercent
+	}
+}
+
+// WithPathMapper renames files on the destination, and records where each
+// one went, so it can still be found once the file is removed from the
+// source. Files aren't moved when the rules change: the copies under the old
+// names are deleted and everything is downloaded again.
+func WithPathMapper(mapper *PathMapper) Option {
+	return func(p *Processor) {
+		p.pathMapper = mapper
+	}
+}
+
+// WithHooks tells every hook about downloaded files and finished syncs.
+func WithHooks(hooks ...Hook) Option {
+	return func(p *Processor) {
+		p.hooks = append(p.hooks, hooks...)
+	}
+}
+
+func BuildProcessor(src Source, db Database, precheck Precheck, dst Destination, log logrus.FieldLogger, opts ...Option) *Processor {
+	p := &Processor{
+		remote:      src,
+		db:          db,
+		local:       dst,
+		precheck:    precheck,
+		log:         log,
+		metrics:     noopMetrics{},
+		concurrency: 1,
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
 }
 
 type Processor struct {
@@ -19,10 +140,50 @@ type Processor struct {
 	local    Destination
 	precheck Precheck
 	log      logrus.FieldLogger
+	metrics  Metrics
+	hooks    []Hook
+
+	fileHooks *hookQueue
+
+	concurrency int
+	newSource   SourceFactory
+
+	verifyChecksums bool
+	verifyLocal     bool
+
+	preserveModTimes bool
+
+	bandwidth *BandwidthLimiter
+
+	twoWay bool
+
+	maxDeletes       int
+	maxDeletePercent float64
+
+	pathMapper *PathMapper
+
+	extract bool
+
+	keepRemoved bool
+	keepFor     time.Duration
+	now         func() time.Time
+
+	remoteFiles *SizeSet
+	localFiles  *SizeSet
+	localPaths  map[string]string
+	stats       *syncStats
+}
+
+// syncStats counts what happened during a single Process, for hooks.
+type syncStats struct {
+	downloaded atomic.Int64
+	failed     atomic.Int64
 }
 
 type FileStatusKey struct {
-	HasRemote, IsRecorded, HasLocal bool
+	HasRemote  bool `json:"remote"`
+	IsRecorded bool `json:"recorded"`
+	HasLocal   bool `json:"local"`
 }
 
 func (key FileStatusKey) String() string {
@@ -56,113 +217,267 @@ var fileStatusActions = map[FileStatusKey]NamedAction{
 }
 
 func (p *Processor) Process(rootPath string) error {
-	var (
-		err error
+	start := time.Now()
+	p.stats = new(syncStats)
+	p.fileHooks = p.startFileHooks()
 
-		remoteFiles *SizeSet
-		dbFiles     *Set
-		localFiles  *SizeSet
-	)
+	err := p.process(rootPath)
 
-	if remoteFiles, err = p.remote.GetAllFiles(rootPath); err != nil {
-		return errors.Wrap(err, "failed to get ftp files")
-	}
-	p.log.WithField("count", remoteFiles.Len()).Info("found remote files")
+	// sync hooks are told about every file, so they go after file hooks
+	p.fileHooks.wait()
+	p.fileHooks = nil
+
+	p.metrics.SyncFinished(time.Since(start), err)
+	p.syncFinished(rootPath, time.Since(start), err)
 
-	if dbFiles, err = p.db.GetAllFiles(rootPath); err != nil {
-		return errors.Wrap(err, "failed to get database files")
+	return err
+}
+
+func (p *Processor) process(rootPath string) error {
+	plan, err := p.Plan(rootPath)
+	if err != nil {
+		return err
 	}
-	p.log.WithField("count", dbFiles.Len()).Info("found recorded files")
 
-	if localFiles, err = p.local.GetAllFiles(rootPath); err != nil {
-		return errors.Wrap(err, "failed to get local files")
+	p.metrics.PlanCreated(plan)
+
+	return p.Execute(plan)
+}
+
+// Execute runs every action in plan, extracts any new archives, then removes
+// any empty directories.
+// Nothing is run if the plan breaks the deletion limit.
+func (p *Processor) Execute(plan *Plan) error {
+	if err := p.checkDeletionLimit(plan); err != nil {
+		return err
 	}
-	p.log.WithField("count", localFiles.Len()).Info("found local files")
 
-	allFiles := NewSet().Union(remoteFiles.ToSet()).Union(dbFiles).Union(localFiles.ToSet())
-	p.log.WithField("count", allFiles.Len()).Info("total files found")
+	p.remoteFiles = plan.remoteFiles
+	p.localFiles = plan.localFiles
+	p.localPaths = plan.localPaths
 
-	for _, file := range allFiles.ToList() {
-		log := p.log.WithField("file", file)
-		hasDbFile := dbFiles.Has(file)
-		localSize, hasLocalFile := localFiles.Get(file)
-		remoteSize, hasRemoteFile := remoteFiles.Get(file)
-		if hasLocalFile && hasRemoteFile && localSize != remoteSize {
-			log.Warning("local file out of sync from remote file, deleting")
-			if err := p.local.Delete(file); err != nil {
+	pool := p.startWorkers()
+
+	for _, action := range plan.Actions {
+		log := p.log.WithField("file", action.Path)
+
+		if action.ReplacesLocal {
+			log.WithField("reason", action.Reason).Warning("local file out of sync from remote file, deleting")
+			if err := p.local.Delete(p.localPath(action.Path)); err != nil {
 				log.WithError(err).Error("failed to delete local file")
 				continue
 			}
-			hasLocalFile = false
 		}
 
-		key := FileStatusKey{
-			IsRecorded: hasDbFile,
-			HasRemote:  hasRemoteFile,
-			HasLocal:   hasLocalFile,
-		}
-		action := fileStatusActions[key]
-
-		if !(key.InSync()) && action.Name != "skip" {
+		if !(action.State.InSync()) && action.Action.Name != "skip" {
 			log.
-				WithField("action", action.Name).
-				WithField("state", key.String()).
+				WithField("action", action.Action.Name).
+				WithField("state", action.State.String()).
 				Info("out of sync")
 		}
 
-		if err = action.Action(key, p, file); err != nil {
-			log.
-				WithField("action", action.Name).
-				WithField("state", key.String()).
-				WithError(err).
-				Error("action failed")
+		if pool != nil && action.Action.Name == "download" {
+			pool.submit(action)
 			continue
 		}
+
+		p.runAction(action)
+	}
+
+	if pool != nil {
+		pool.wait()
 	}
 
-	if err = p.local.CleanDirectories(rootPath); err != nil {
+	if p.extract && !p.twoWay {
+		p.extractArchives()
+	}
+
+	if err := p.local.CleanDirectories(plan.Root); err != nil {
 		return errors.Wrap(err, "failed to clean directories")
 	}
 
+	if p.twoWay {
+		if err := p.remote.(Destination).CleanDirectories(plan.Root); err != nil {
+			return errors.Wrap(err, "failed to clean remote directories")
+		}
+	}
+
 	return nil
 }
 
+func (p *Processor) runAction(action PlannedAction) {
+	err := action.Action.Action(action.State, p, action.Path)
+	p.metrics.ActionFinished(action.Action.Name, err)
+
+	if err != nil {
+		if p.stats != nil {
+			p.stats.failed.Add(1)
+		}
+
+		p.log.
+			WithField("file", action.Path).
+			WithField("action", action.Action.Name).
+			WithField("state", action.State.String()).
+			WithError(err).
+			Error("action failed")
+	}
+}
+
 func downloadFile(_ FileStatusKey, p *Processor, path string) error {
+	if remoteInfo, _ := p.remoteFiles.GetInfo(path); remoteInfo.Link != "" {
+		return p.createLink(path, remoteInfo.Link)
+	}
+
+	ok, err := p.transfer(path)
+	if err != nil || !ok {
+		return err
+	}
+
+	p.fileDownloaded(path)
+	return nil
+}
+
+// fileDownloaded tells every hook that path was downloaded.
+func (p *Processor) fileDownloaded(path string) {
+	if p.stats != nil {
+		p.stats.downloaded.Add(1)
+	}
+
+	remoteInfo, _ := p.remoteFiles.GetInfo(path)
+	event := FileEvent{Path: path, LocalPath: p.localPath(path), Size: remoteInfo.Size}
+
+	if p.fileHooks != nil {
+		p.fileHooks.events <- event
+		return
+	}
+
+	p.runFileHooks(event)
+}
+
+func (p *Processor) runFileHooks(event FileEvent) {
+	for _, hook := range p.hooks {
+		if err := hook.FileDownloaded(event); err != nil {
+			p.log.WithField("path", event.Path).WithError(err).Error("file hook failed")
+		}
+	}
+}
+
+// syncFinished tells every hook that a sync of rootPath finished.
+func (p *Processor) syncFinished(rootPath string, duration time.Duration, err error) {
+	event := SyncEvent{
+		Root:       rootPath,
+		Downloaded: p.stats.downloaded.Load(),
+		Failed:     p.stats.failed.Load(),
+		Duration:   duration,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	for _, hook := range p.hooks {
+		if err := hook.SyncFinished(event); err != nil {
+			p.log.WithError(err).Error("sync hook failed")
+		}
+	}
+}
+
+// discard removes a file that transfer wrote but can't use, which isn't
+// worth keeping even when deleted files are.
+func (p *Processor) discard(localPath string) error {
+	if discarder, ok := p.local.(Discarder); ok {
+		return discarder.Discard(localPath)
+	}
+
+	return p.local.Delete(localPath)
+}
+
+// transfer copies path from p.remote to p.local, and reports whether it
+// did; the precheck can hold files back for a later run.
+func (p *Processor) transfer(path string) (bool, error) {
 	log := p.log.WithField("path", path)
 
 	if p.precheck != nil {
 		log.Info("checking to see if file should be downloaded")
 		ok, err := p.precheck.IsFileReady(path)
 		if err != nil {
-			return errors.Wrap(err, "failed to precheck file")
+			return false, errors.Wrap(err, "failed to precheck file")
 		}
 
 		if !ok {
 			log.Info("skipping file, not yet ready")
-			return nil
+			return false, nil
 		}
 
 		log.Info("file is ready for download")
 	}
 
-	log.Info("downloading")
+	remoteInfo, _ := p.remoteFiles.GetInfo(path)
+	remoteSize := remoteInfo.Size
 
-	fp, err := p.remote.Read(path)
+	localPath := p.localPath(path)
+
+	offset, err := p.local.GetPartialSize(localPath)
 	if err != nil {
-		return errors.Wrapf(err, "failed to read %s", path)
+		return false, errors.Wrapf(err, "failed to check for partial download of %s", path)
+	}
+	if offset > remoteSize {
+		log.WithField("offset", offset).Warning("partial download is larger than remote file, starting over")
+		offset = 0
 	}
 
-	start := time.Now()
-	bytes, err := p.local.Write(path, fp)
+	if offset > 0 {
+		log.WithField("offset", offset).Info("resuming download")
+	} else {
+		log.Info("downloading")
+	}
+
+	fp, err := p.remote.Read(path, offset)
 	if err != nil {
-		return fmt.Errorf("failed to write %s (wrote %d bytes): %w", path, bytes, err)
+		return false, errors.Wrapf(err, "failed to read %s", path)
 	}
+
+	if p.bandwidth != nil {
+		fp = p.bandwidth.Reader(fp)
+	}
+
 	defer func() {
 		if err := fp.Close(); err != nil {
 			fmt.Printf("failed to close reader for %s: %v", path, err)
 		}
 	}()
 
+	start := time.Now()
+	bytes, err := p.local.Write(localPath, offset, fp)
+	p.metrics.Downloaded(bytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to write %s (wrote %d bytes): %w", path, bytes, err)
+	}
+
+	if localSize := offset + bytes; localSize != remoteSize {
+		if err = p.discard(localPath); err != nil {
+			log.WithError(err).Error("failed to delete incomplete file")
+		}
+		return false, fmt.Errorf("downloaded %d bytes of %s, expected %d", localSize, path, remoteSize)
+	}
+
+	var checksum Checksum
+	if p.verifyChecksums {
+		if checksum, err = p.verifyChecksum(log, path); err != nil {
+			if err := p.discard(localPath); err != nil {
+				log.WithError(err).Error("failed to delete unverified file")
+			}
+			return false, err
+		}
+	}
+
+	if p.preserveModTimes && !remoteInfo.ModTime.IsZero() {
+		if setter, ok := p.local.(ModTimeSetter); ok {
+			if err = setter.SetModTime(localPath, remoteInfo.ModTime); err != nil {
+				log.WithError(err).Warning("failed to set modification time")
+			}
+		}
+	}
+
 	done := time.Since(start)
 	log.WithFields(logrus.Fields{
 		"bytes_str": fmtSize(bytes),
@@ -171,12 +486,100 @@ func downloadFile(_ FileStatusKey, p *Processor, path string) error {
 		"speed":     fmtSpeed(bytes, done),
 	}).Info("download complete")
 	if err = p.db.Record(path); err != nil {
-		return errors.Wrapf(err, "failed to record %s", path)
+		return false, errors.Wrapf(err, "failed to record %s", path)
+	}
+
+	if err = p.recordLocalPath(path); err != nil {
+		return false, err
+	}
+
+	// without a new checksum, the old one is for different contents
+	if err = p.db.RecordChecksum(path, checksum); err != nil {
+		return false, errors.Wrapf(err, "failed to record checksum for %s", path)
+	}
+
+	if p.twoWay {
+		// the state of both sides is recorded once this returns
+		return true, nil
+	}
+
+	return true, p.recordModTime(path, remoteInfo.ModTime)
+}
+
+func (p *Processor) recordModTime(path string, modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+
+	if err := p.db.RecordModTime(path, modTime); err != nil {
+		return errors.Wrapf(err, "failed to record modification time for %s", path)
 	}
 
 	return nil
 }
 
+// verifyChecksum compares the local copy of path to the source's checksum,
+// if the source has one, and returns the local checksum.
+func (p *Processor) verifyChecksum(log logrus.FieldLogger, path string) (Checksum, error) {
+	local, ok := p.local.(Checksummer)
+	if !ok {
+		log.Debug("destination does not support checksums")
+		return Checksum{}, nil
+	}
+
+	var (
+		remoteChecksum Checksum
+		err            error
+	)
+
+	if remote, ok := p.remote.(Checksummer); ok {
+		remoteChecksum, err = remote.Checksum(path, ChecksumAlgorithms...)
+		if errors.Is(err, ErrChecksumUnsupported) {
+			log.Debug("source does not support checksums")
+		} else if err != nil {
+			return Checksum{}, errors.Wrapf(err, "failed to get remote checksum for %s", path)
+		}
+	}
+
+	algorithm := SHA256
+	if !remoteChecksum.IsEmpty() {
+		algorithm = remoteChecksum.Algorithm
+	}
+
+	localChecksum, err := local.Checksum(p.localPath(path), algorithm)
+	if err != nil {
+		return Checksum{}, errors.Wrapf(err, "failed to get local checksum for %s", path)
+	}
+
+	if !remoteChecksum.IsEmpty() && !strings.EqualFold(localChecksum.Value, remoteChecksum.Value) {
+		return Checksum{}, fmt.Errorf("checksum mismatch for %s: local %s, remote %s", path, localChecksum, remoteChecksum)
+	}
+
+	return localChecksum, nil
+}
+
+func (p *Processor) isLocalCorrupt(path string) (bool, error) {
+	local, ok := p.local.(Checksummer)
+	if !ok {
+		return false, nil
+	}
+
+	recorded, err := p.db.GetChecksum(path)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get recorded checksum for %s", path)
+	}
+	if recorded.IsEmpty() {
+		return false, nil
+	}
+
+	actual, err := local.Checksum(p.localPath(path), recorded.Algorithm)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get local checksum for %s", path)
+	}
+
+	return !strings.EqualFold(actual.Value, recorded.Value), nil
+}
+
 func recordFile(_ FileStatusKey, p *Processor, path string) error {
 	log := p.log.WithField("path", path)
 	log.Info("recording")
@@ -185,7 +588,12 @@ func recordFile(_ FileStatusKey, p *Processor, path string) error {
 		return errors.Wrapf(err, "failed to record %s", path)
 	}
 
-	return nil
+	if err := p.recordLocalPath(path); err != nil {
+		return err
+	}
+
+	remoteInfo, _ := p.remoteFiles.GetInfo(path)
+	return p.recordModTime(path, remoteInfo.ModTime)
 }
 
 func skipFile(_ FileStatusKey, _ *Processor, _ string) error {
@@ -204,7 +612,7 @@ func deleteFile(key FileStatusKey, p *Processor, path string) error {
 
 	if key.HasLocal {
 		log.Info("deleting local file")
-		if err := p.local.Delete(path); err != nil {
+		if err := p.local.Delete(p.localPath(path)); err != nil {
 			return errors.Wrap(err, "error deleting file")
 		}
 	}
@@ -0,0 +1,1037 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memSource struct {
+	files     map[string]string
+	checksums map[string]Checksum
+	modTimes  map[string]time.Time
+	links     map[string]string
+	delay     time.Duration
+
+	active, maxActive *atomic.Int32
+}
+
+func newMemSource(files map[string]string) *memSource {
+	return &memSource{files: files, active: new(atomic.Int32), maxActive: new(atomic.Int32)}
+}
+
+func (m *memSource) Read(path string, offset int64) (io.ReadCloser, error) {
+	content, ok := m.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	active := m.active.Add(1)
+	defer m.active.Add(-1)
+	for {
+		current := m.maxActive.Load()
+		if active <= current || m.maxActive.CompareAndSwap(current, active) {
+			break
+		}
+	}
+	time.Sleep(m.delay)
+
+	return io.NopCloser(strings.NewReader(content[offset:])), nil
+}
+
+func (m *memSource) GetAllFiles(string) (*SizeSet, error) {
+	files := NewSizeSet()
+	for path, content := range m.files {
+		files.SetInfo(path, FileInfo{Size: int64(len(content)), ModTime: m.modTimes[path]})
+	}
+	for path, target := range m.links {
+		files.SetInfo(path, FileInfo{Link: target})
+	}
+	return files, nil
+}
+
+func (m *memSource) Checksum(path string, _ ...string) (Checksum, error) {
+	checksum, ok := m.checksums[path]
+	if !ok {
+		return Checksum{}, ErrChecksumUnsupported
+	}
+	return checksum, nil
+}
+
+func (m *memSource) Close() error {
+	return nil
+}
+
+type memDestination struct {
+	lock     sync.Mutex
+	files    map[string][]byte
+	modTimes map[string]time.Time
+}
+
+func newMemDestination(files map[string]string) *memDestination {
+	d := &memDestination{files: make(map[string][]byte), modTimes: make(map[string]time.Time)}
+	for path, content := range files {
+		d.files[path] = []byte(content)
+	}
+	return d
+}
+
+func (m *memDestination) GetAllFiles(string) (*SizeSet, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	files := NewSizeSet()
+	for path, content := range m.files {
+		files.Set(path, int64(len(content)))
+	}
+	return files, nil
+}
+
+func (m *memDestination) GetPartialSize(string) (int64, error) {
+	return 0, nil
+}
+
+func (m *memDestination) Delete(path string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.files, path)
+	return nil
+}
+
+func (m *memDestination) Exists(path string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, ok := m.files[path]
+	return ok, nil
+}
+
+func (m *memDestination) Write(path string, _ int64, fp io.ReadCloser) (int64, error) {
+	var buf bytes.Buffer
+	size, err := io.Copy(&buf, fp)
+	if err != nil {
+		return size, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.files[path] = buf.Bytes()
+	return size, nil
+}
+
+func (m *memDestination) Checksum(path string, algorithms ...string) (Checksum, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return HashReader(bytes.NewReader(m.files[path]), algorithms[0])
+}
+
+func (m *memDestination) Read(path string, offset int64) (io.ReadCloser, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	content, ok := m.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(content[offset:])), nil
+}
+
+func (m *memDestination) Rename(from, to string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.files[to] = m.files[from]
+	delete(m.files, from)
+	return nil
+}
+
+func (m *memDestination) Close() error {
+	return nil
+}
+
+func (m *memDestination) SetModTime(path string, modTime time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.modTimes[path] = modTime
+	return nil
+}
+
+func (m *memDestination) CleanDirectories(string) error {
+	return nil
+}
+
+func (m *memDestination) contents() map[string]string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	result := make(map[string]string)
+	for path, content := range m.files {
+		result[path] = string(content)
+	}
+	return result
+}
+
+type memDatabase struct {
+	lock      sync.Mutex
+	files     *Set
+	checksums map[string]Checksum
+	modTimes  map[string]time.Time
+	states    map[string]SyncedState
+	removed   map[string]time.Time
+	locals    map[string]string
+	extracted map[string]bool
+	archives  map[string]string
+}
+
+func newMemDatabase(paths ...string) *memDatabase {
+	d := &memDatabase{
+		files:     NewSet(),
+		checksums: make(map[string]Checksum),
+		modTimes:  make(map[string]time.Time),
+		states:    make(map[string]SyncedState),
+		removed:   make(map[string]time.Time),
+		locals:    make(map[string]string),
+		extracted: make(map[string]bool),
+		archives:  make(map[string]string),
+	}
+	for _, path := range paths {
+		d.files.Set(path)
+	}
+	return d
+}
+
+func (m *memDatabase) GetAllFiles(string) (*Set, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return NewSet().Union(m.files), nil
+}
+
+func (m *memDatabase) Exists(path string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.files.Has(path), nil
+}
+
+func (m *memDatabase) Record(path string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.files.Set(path)
+	delete(m.removed, path)
+	delete(m.extracted, path)
+	return nil
+}
+
+func (m *memDatabase) RecordChecksum(path string, checksum Checksum) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if checksum.IsEmpty() {
+		delete(m.checksums, path)
+	} else {
+		m.checksums[path] = checksum
+	}
+	return nil
+}
+
+func (m *memDatabase) GetChecksum(path string) (Checksum, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.checksums[path], nil
+}
+
+func (m *memDatabase) RecordModTime(path string, modTime time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.modTimes[path] = modTime
+	return nil
+}
+
+func (m *memDatabase) GetModTime(path string) (time.Time, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.modTimes[path], nil
+}
+
+func (m *memDatabase) RecordSyncedState(path string, state SyncedState) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.states[path] = state
+	return nil
+}
+
+func (m *memDatabase) GetSyncedState(path string) (SyncedState, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state, ok := m.states[path]
+	return state, ok, nil
+}
+
+func (m *memDatabase) RecordTombstone(path string, removedAt time.Time) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.removed[path] = removedAt
+	return nil
+}
+
+func (m *memDatabase) GetTombstone(path string) (time.Time, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.removed[path], nil
+}
+
+func (m *memDatabase) RecordLocalPath(path, localPath string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.locals[path] = localPath
+	return nil
+}
+
+func (m *memDatabase) GetLocalPaths(string) (map[string]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	localPaths := make(map[string]string, len(m.locals))
+	for path, localPath := range m.locals {
+		localPaths[path] = localPath
+	}
+	return localPaths, nil
+}
+
+func (m *memDatabase) RecordExtraction(archive string, files []string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.extracted[archive] = true
+	for _, path := range files {
+		m.archives[path] = archive
+	}
+	return nil
+}
+
+func (m *memDatabase) IsExtracted(archive string) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.extracted[archive], nil
+}
+
+func (m *memDatabase) GetExtractedFiles(string) (map[string]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	archives := make(map[string]string, len(m.archives))
+	for path, archive := range m.archives {
+		archives[path] = archive
+	}
+	return archives, nil
+}
+
+func (m *memDatabase) Delete(path string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.files.Unset(path)
+	delete(m.checksums, path)
+	delete(m.modTimes, path)
+	delete(m.states, path)
+	delete(m.removed, path)
+	delete(m.locals, path)
+	delete(m.extracted, path)
+	for extracted, archive := range m.archives {
+		if archive == path {
+			delete(m.archives, extracted)
+		}
+	}
+	return nil
+}
+
+func (m *memDatabase) Close() error {
+	return nil
+}
+
+func newTestLogger() logrus.FieldLogger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func TestProcess(t *testing.T) {
+	src := newMemSource(map[string]string{
+		"/new.txt":      "new",
+		"/recorded.txt": "recorded",
+		"/changed.txt":  "changed",
+	})
+	dst := newMemDestination(map[string]string{
+		"/changed.txt": "old",
+		"/removed.txt": "removed",
+		"/stray.txt":   "stray",
+	})
+	db := newMemDatabase("/recorded.txt", "/changed.txt", "/removed.txt")
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger())
+	require.NoError(t, p.Process("/"))
+
+	assert.Equal(t, map[string]string{
+		"/new.txt":      "new",
+		"/recorded.txt": "recorded",
+		"/changed.txt":  "changed",
+	}, dst.contents())
+	assert.ElementsMatch(t, []string{"/new.txt", "/recorded.txt", "/changed.txt"}, db.files.ToList())
+}
+
+func TestProcessConcurrently(t *testing.T) {
+	files := make(map[string]string)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		files["/"+name] = strings.Repeat(name, 10)
+	}
+
+	src := newMemSource(files)
+	src.delay = 20 * time.Millisecond
+
+	var opened atomic.Int32
+	newSource := func() (Source, error) {
+		opened.Add(1)
+		return &memSource{files: files, delay: src.delay, active: src.active, maxActive: src.maxActive}, nil
+	}
+
+	dst := newMemDestination(nil)
+	db := newMemDatabase()
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithConcurrency(4, newSource))
+	require.NoError(t, p.Process("/"))
+
+	assert.Equal(t, files, dst.contents())
+	assert.Equal(t, len(files), db.files.Len())
+	assert.Equal(t, int32(3), opened.Load())
+	assert.Greater(t, src.maxActive.Load(), int32(1))
+}
+
+func sha256Of(t *testing.T, content string) Checksum {
+	checksum, err := HashReader(strings.NewReader(content), SHA256)
+	require.NoError(t, err)
+	return checksum
+}
+
+func TestProcessVerifiesChecksums(t *testing.T) {
+	src := newMemSource(map[string]string{
+		"/good.txt": "good",
+		"/bad.txt":  "bad",
+		"/none.txt": "none",
+	})
+	src.checksums = map[string]Checksum{
+		"/good.txt": sha256Of(t, "good"),
+		"/bad.txt":  sha256Of(t, "something else"),
+	}
+	dst := newMemDestination(nil)
+	db := newMemDatabase()
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithChecksumVerification())
+	require.NoError(t, p.Process("/"))
+
+	assert.Equal(t, map[string]string{"/good.txt": "good", "/none.txt": "none"}, dst.contents())
+	assert.ElementsMatch(t, []string{"/good.txt", "/none.txt"}, db.files.ToList())
+	assert.Equal(t, map[string]Checksum{
+		"/good.txt": sha256Of(t, "good"),
+		"/none.txt": sha256Of(t, "none"),
+	}, db.checksums)
+}
+
+func TestProcessRedownloadsCorruptFiles(t *testing.T) {
+	src := newMemSource(map[string]string{"/a.txt": "good"})
+	dst := newMemDestination(map[string]string{"/a.txt": "baad"})
+	db := newMemDatabase("/a.txt")
+	db.checksums["/a.txt"] = sha256Of(t, "good")
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger())
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{"/a.txt": "baad"}, dst.contents())
+
+	p = BuildProcessor(src, db, nil, dst, newTestLogger(), WithLocalVerification())
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{"/a.txt": "good"}, dst.contents())
+}
+
+func TestDownloadsForgetOldChecksums(t *testing.T) {
+	src := newMemSource(map[string]string{"/a.txt": "new"})
+	dst := newMemDestination(map[string]string{"/a.txt": "old!"})
+	db := newMemDatabase("/a.txt")
+	db.checksums["/a.txt"] = sha256Of(t, "old!")
+
+	// downloaded again for the size mismatch, without checksums
+	p := BuildProcessor(src, db, nil, dst, newTestLogger())
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{"/a.txt": "new"}, dst.contents())
+	assert.Empty(t, db.checksums)
+}
+
+func TestLocalVerificationRecordsChecksums(t *testing.T) {
+	src := newMemSource(map[string]string{"/a.txt": "good"})
+	dst := newMemDestination(nil)
+	db := newMemDatabase()
+
+	// without checksums there'd be nothing to verify against next time
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithLocalVerification())
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]Checksum{"/a.txt": sha256Of(t, "good")}, db.checksums)
+}
+
+func TestProcessRedownloadsChangedFiles(t *testing.T) {
+	before := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	after := before.Add(time.Hour)
+
+	src := newMemSource(map[string]string{"/changed.txt": "new", "/old.txt": "old"})
+	src.modTimes = map[string]time.Time{"/changed.txt": after, "/old.txt": before}
+	dst := newMemDestination(map[string]string{"/changed.txt": "old", "/old.txt": "old"})
+	db := newMemDatabase("/changed.txt", "/old.txt")
+	db.modTimes["/changed.txt"] = before
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithPreservedModTimes())
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+
+	var actions []string
+	for _, action := range plan.Actions {
+		actions = append(actions, fmt.Sprintf("%s %s %s", action.Action.Name, action.Path, action.Reason))
+	}
+	assert.Equal(t, []string{
+		"download /changed.txt remote changed",
+		"record /old.txt missing modification time",
+	}, actions)
+
+	require.NoError(t, p.Execute(plan))
+	assert.Equal(t, map[string]string{"/changed.txt": "new", "/old.txt": "old"}, dst.contents())
+	assert.Equal(t, map[string]time.Time{"/changed.txt": after, "/old.txt": before}, db.modTimes)
+	assert.Equal(t, map[string]time.Time{"/changed.txt": after}, dst.modTimes)
+
+	plan, err = p.Plan("/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"skip": 2}, plan.Counts())
+}
+
+func TestPlanChangesNothing(t *testing.T) {
+	src := newMemSource(map[string]string{
+		"/new.txt":      "new",
+		"/recorded.txt": "recorded",
+		"/changed.txt":  "changed",
+	})
+	dst := newMemDestination(map[string]string{
+		"/changed.txt":  "old",
+		"/recorded.txt": "recorded",
+		"/stray.txt":    "stray",
+	})
+	db := newMemDatabase("/recorded.txt", "/changed.txt")
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger())
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+
+	var actions []string
+	for _, action := range plan.Actions {
+		actions = append(actions, fmt.Sprintf("%s %s %s", action.Action.Name, action.Path, action.Reason))
+	}
+	assert.Equal(t, []string{
+		"download /changed.txt size mismatch",
+		"download /new.txt ",
+		"skip /recorded.txt ",
+		"delete /stray.txt ",
+	}, actions)
+	assert.Equal(t, map[string]int{"download": 2, "skip": 1, "delete": 1}, plan.Counts())
+
+	assert.Equal(t, map[string]string{
+		"/changed.txt":  "old",
+		"/recorded.txt": "recorded",
+		"/stray.txt":    "stray",
+	}, dst.contents())
+	assert.ElementsMatch(t, []string{"/recorded.txt", "/changed.txt"}, db.files.ToList())
+
+	var text bytes.Buffer
+	require.NoError(t, plan.WriteText(&text))
+	assert.Contains(t, text.String(), "redownload")
+	assert.NotContains(t, text.String(), "skip  ")
+	assert.Contains(t, text.String(), "3 remote, 2 recorded, 3 local: 1 delete, 2 download, 1 skip")
+
+	var data bytes.Buffer
+	require.NoError(t, plan.WriteJSON(&data))
+	assert.Contains(t, data.String(), `"action": "delete"`)
+}
+
+type recordingMetrics struct {
+	lock     sync.Mutex
+	actions  map[string]int
+	failures map[string]int
+	bytes    int64
+	plans    int
+	syncs    int
+	blocked  int
+}
+
+func (m *recordingMetrics) PlanCreated(*Plan) {
+	m.plans++
+}
+
+func (m *recordingMetrics) ActionFinished(action string, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.actions[action]++
+	if err != nil {
+		m.failures[action]++
+	}
+}
+
+func (m *recordingMetrics) Downloaded(bytes int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.bytes += bytes
+}
+
+func (m *recordingMetrics) DeletesBlocked(int) {
+	m.blocked++
+}
+
+func (m *recordingMetrics) SyncFinished(time.Duration, error) {
+	m.syncs++
+}
+
+func TestProcessReportsMetrics(t *testing.T) {
+	src := newMemSource(map[string]string{"/a.txt": "aaa", "/b.txt": "bb"})
+	src.checksums = map[string]Checksum{"/b.txt": sha256Of(t, "something else")}
+	dst := newMemDestination(map[string]string{"/stray.txt": "stray"})
+	db := newMemDatabase()
+	metrics := &recordingMetrics{actions: make(map[string]int), failures: make(map[string]int)}
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithChecksumVerification(), WithMetrics(metrics))
+	require.NoError(t, p.Process("/"))
+
+	assert.Equal(t, map[string]int{"download": 2, "delete": 1}, metrics.actions)
+	assert.Equal(t, map[string]int{"download": 1}, metrics.failures)
+	assert.Equal(t, int64(5), metrics.bytes)
+	assert.Equal(t, 1, metrics.plans)
+	assert.Equal(t, 1, metrics.syncs)
+}
+
+func TestProcessStopsMassDeletes(t *testing.T) {
+	files := map[string]string{"/a.txt": "a", "/b.txt": "b", "/c.txt": "c"}
+
+	// the source briefly lists nothing
+	src := newMemSource(map[string]string{})
+	dst := newMemDestination(files)
+	db := newMemDatabase("/a.txt", "/b.txt", "/c.txt")
+	metrics := &recordingMetrics{actions: make(map[string]int), failures: make(map[string]int)}
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithDeletionLimit(0, 50), WithMetrics(metrics))
+	err := p.Process("/")
+	require.ErrorIs(t, err, ErrTooManyDeletes)
+	assert.Equal(t, files, dst.contents())
+	assert.Equal(t, 3, db.files.Len())
+	assert.Equal(t, 1, metrics.blocked)
+	assert.Empty(t, metrics.actions)
+
+	// plans say so too, without counting as a blocked sync
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+	assert.Contains(t, plan.Blocked, "3 of 3 local files")
+	var text strings.Builder
+	require.NoError(t, plan.WriteText(&text))
+	assert.Contains(t, text.String(), "nothing will be done: too many files would be deleted")
+	assert.Equal(t, 1, metrics.blocked)
+
+	p = BuildProcessor(src, db, nil, dst, newTestLogger(), WithDeletionLimit(3, 0))
+	require.NoError(t, p.Process("/"))
+	assert.Empty(t, dst.contents())
+}
+
+func TestProcessStopsMassReplacements(t *testing.T) {
+	files := map[string]string{"/a.txt": "a", "/b.txt": "b", "/c.txt": "c"}
+
+	// the source briefly lists the wrong sizes
+	src := newMemSource(map[string]string{"/a.txt": "aa", "/b.txt": "bb", "/c.txt": "cc"})
+	dst := newMemDestination(files)
+	db := newMemDatabase("/a.txt", "/b.txt", "/c.txt")
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithDeletionLimit(2, 0))
+	err := p.Process("/")
+	require.ErrorIs(t, err, ErrTooManyDeletes)
+	assert.Equal(t, files, dst.contents())
+}
+
+// brokenStateDatabase can't read synced states.
+type brokenStateDatabase struct {
+	*memDatabase
+}
+
+func (brokenStateDatabase) GetSyncedState(string) (SyncedState, bool, error) {
+	return SyncedState{}, false, errors.New("database is locked")
+}
+
+func TestTwoWaySyncSkipsFilesWithoutState(t *testing.T) {
+	remote := newMemDestination(map[string]string{"/changed.txt": "remote edit"})
+	local := newMemDestination(map[string]string{"/changed.txt": "v1", "/gone-remote.txt": "y"})
+	db := brokenStateDatabase{newMemDatabase("/changed.txt", "/gone-remote.txt")}
+
+	p := BuildProcessor(remote, db, nil, local, newTestLogger(), WithTwoWaySync())
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"log": 2}, plan.Counts())
+
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{"/changed.txt": "v1", "/gone-remote.txt": "y"}, local.contents())
+	assert.Equal(t, map[string]string{"/changed.txt": "remote edit"}, remote.contents())
+}
+
+func TestTwoWaySync(t *testing.T) {
+	remote := newMemDestination(map[string]string{
+		"/r.txt":              "remote new",
+		"/both.txt":           "same",
+		"/gone-local.txt":     "x",
+		"/changed-remote.txt": "v2 remote",
+		"/changed-local.txt":  "v1",
+		"/conflict.txt":       "remote edit",
+	})
+	local := newMemDestination(map[string]string{
+		"/l.txt":              "local new",
+		"/both.txt":           "same",
+		"/gone-remote.txt":    "y",
+		"/changed-remote.txt": "v1",
+		"/changed-local.txt":  "v2 local!",
+		"/conflict.txt":       "local edit!!",
+	})
+	db := newMemDatabase("/gone-local.txt", "/gone-remote.txt", "/changed-remote.txt", "/changed-local.txt", "/conflict.txt")
+	db.states["/gone-local.txt"] = SyncedState{Remote: FileInfo{Size: 1}, Local: FileInfo{Size: 1}}
+	db.states["/gone-remote.txt"] = SyncedState{Remote: FileInfo{Size: 1}, Local: FileInfo{Size: 1}}
+	db.states["/changed-remote.txt"] = SyncedState{Remote: FileInfo{Size: 2}, Local: FileInfo{Size: 2}}
+	db.states["/changed-local.txt"] = SyncedState{Remote: FileInfo{Size: 2}, Local: FileInfo{Size: 2}}
+	db.states["/conflict.txt"] = SyncedState{Remote: FileInfo{Size: 1}, Local: FileInfo{Size: 1}}
+
+	p := BuildProcessor(remote, db, nil, local, newTestLogger(), WithTwoWaySync())
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+
+	var actions []string
+	for _, action := range plan.Actions {
+		actions = append(actions, fmt.Sprintf("%s %s %s", action.Action.Name, action.Path, action.Reason))
+	}
+	assert.Equal(t, []string{
+		"record /both.txt ",
+		"upload /changed-local.txt local changed",
+		"download /changed-remote.txt remote changed",
+		"conflict /conflict.txt changed on both sides",
+		"delete-remote /gone-local.txt ",
+		"delete /gone-remote.txt ",
+		"upload /l.txt ",
+		"download /r.txt ",
+	}, actions)
+
+	require.NoError(t, p.Execute(plan))
+
+	expected := map[string]string{
+		"/r.txt":              "remote new",
+		"/l.txt":              "local new",
+		"/both.txt":           "same",
+		"/changed-remote.txt": "v2 remote",
+		"/changed-local.txt":  "v2 local!",
+		"/conflict.txt":       "remote edit",
+	}
+	assert.Equal(t, expected, remote.contents())
+
+	localContents := local.contents()
+	var conflictCopy string
+	for path, content := range localContents {
+		if strings.HasPrefix(path, "/conflict.txt.conflict-") {
+			conflictCopy = path
+			assert.Equal(t, "local edit!!", content)
+			delete(localContents, path)
+		}
+	}
+	require.NotEmpty(t, conflictCopy)
+	assert.Equal(t, expected, localContents)
+	assert.Equal(t, SyncedState{Remote: FileInfo{Size: 9}, Local: FileInfo{Size: 9}}, db.states["/changed-remote.txt"])
+
+	// the conflicting copy is uploaded on the next run, then everything is
+	// in sync
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, "local edit!!", remote.contents()[conflictCopy])
+
+	plan, err = p.Plan("/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"skip": 7}, plan.Counts())
+}
+
+type staticPrecheck struct {
+	ready bool
+	err   error
+}
+
+func (s staticPrecheck) IsFileReady(string) (bool, error) {
+	return s.ready, s.err
+}
+
+func (s staticPrecheck) Close() error {
+	return nil
+}
+
+func TestTwoWayConflictRestoresLocalCopy(t *testing.T) {
+	testCases := map[string]staticPrecheck{
+		"not ready":       {ready: false},
+		"transfer failed": {err: errors.New("precheck failed")},
+	}
+
+	for name, precheck := range testCases {
+		t.Run(name, func(t *testing.T) {
+			remote := newMemDestination(map[string]string{"/conflict.txt": "remote edit"})
+			local := newMemDestination(map[string]string{"/conflict.txt": "local edit!!"})
+			db := newMemDatabase("/conflict.txt")
+			db.states["/conflict.txt"] = SyncedState{Remote: FileInfo{Size: 1}, Local: FileInfo{Size: 1}}
+
+			p := BuildProcessor(remote, db, precheck, local, newTestLogger(), WithTwoWaySync())
+			_ = p.Process("/")
+
+			// the conflict is still there for the next run
+			assert.Equal(t, map[string]string{"/conflict.txt": "local edit!!"}, local.contents())
+		})
+	}
+}
+
+func TestProcessKeepsRemovedFiles(t *testing.T) {
+	now := time.Date(2023, 5, 31, 0, 0, 0, 0, time.UTC)
+
+	src := newMemSource(map[string]string{"/kept.txt": "kept"})
+	dst := newMemDestination(map[string]string{
+		"/kept.txt": "kept", "/gone.txt": "gone", "/expired.txt": "expired", "/recent.txt": "recent",
+	})
+	db := newMemDatabase("/kept.txt", "/gone.txt", "/expired.txt", "/recent.txt")
+	db.removed["/expired.txt"] = now.Add(-40 * 24 * time.Hour)
+	db.removed["/recent.txt"] = now.Add(-24 * time.Hour)
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithRetention(30*24*time.Hour))
+	p.now = func() time.Time { return now }
+
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+
+	var actions []string
+	for _, action := range plan.Actions {
+		actions = append(actions, fmt.Sprintf("%s %s %s", action.Action.Name, action.Path, action.Reason))
+	}
+	assert.Equal(t, []string{
+		"delete /expired.txt retention expired",
+		"tombstone /gone.txt removed from source",
+		"skip /kept.txt ",
+		"skip /recent.txt removed from source",
+	}, actions)
+
+	require.NoError(t, p.Execute(plan))
+	assert.Equal(t, map[string]string{"/kept.txt": "kept", "/gone.txt": "gone", "/recent.txt": "recent"}, dst.contents())
+	assert.Equal(t, now, db.removed["/gone.txt"])
+
+	// coming back to the source doesn't bring it back
+	src.files["/gone.txt"] = "changed"
+	require.NoError(t, dst.Delete("/gone.txt"))
+
+	plan, err = p.Plan("/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"skip": 3}, plan.Counts())
+}
+
+type recordingHook struct {
+	lock  sync.Mutex
+	files []FileEvent
+	syncs []SyncEvent
+}
+
+func (h *recordingHook) FileDownloaded(event FileEvent) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.files = append(h.files, event)
+	return errors.New("hook failed")
+}
+
+func (h *recordingHook) SyncFinished(event SyncEvent) error {
+	h.syncs = append(h.syncs, event)
+	return nil
+}
+
+func TestProcessRunsHooks(t *testing.T) {
+	src := newMemSource(map[string]string{"/a.txt": "aaa", "/b.txt": "bb"})
+	src.checksums = map[string]Checksum{"/b.txt": sha256Of(t, "something else")}
+	dst := newMemDestination(map[string]string{})
+	hook := &recordingHook{}
+
+	p := BuildProcessor(src, newMemDatabase(), nil, dst, newTestLogger(), WithChecksumVerification(), WithHooks(hook))
+
+	// failing hooks don't fail the sync
+	require.NoError(t, p.Process("/"))
+
+	assert.Equal(t, []FileEvent{{Path: "/a.txt", LocalPath: "/a.txt", Size: 3}}, hook.files)
+	require.Len(t, hook.syncs, 1)
+	assert.Equal(t, "/", hook.syncs[0].Root)
+	assert.Equal(t, int64(1), hook.syncs[0].Downloaded)
+	assert.Equal(t, int64(1), hook.syncs[0].Failed)
+	assert.Empty(t, hook.syncs[0].Error)
+}
+
+// blockingHook holds up every file hook until release is closed.
+type blockingHook struct {
+	release chan struct{}
+}
+
+func (h *blockingHook) FileDownloaded(FileEvent) error {
+	<-h.release
+	return nil
+}
+
+func (h *blockingHook) SyncFinished(SyncEvent) error {
+	return nil
+}
+
+func TestSlowHooksDontBlockDownloads(t *testing.T) {
+	files := map[string]string{"/a.txt": "a", "/b.txt": "b", "/c.txt": "c"}
+	dst := newMemDestination(map[string]string{})
+	hook := &blockingHook{release: make(chan struct{})}
+
+	p := BuildProcessor(newMemSource(files), newMemDatabase(), nil, dst, newTestLogger(), WithHooks(hook))
+
+	done := make(chan error)
+	go func() { done <- p.Process("/") }()
+
+	assert.Eventually(t, func() bool {
+		return len(dst.contents()) == len(files)
+	}, time.Second, time.Millisecond)
+
+	// the sync still waits for its hooks
+	select {
+	case <-done:
+		t.Fatal("sync finished before its hooks")
+	default:
+	}
+
+	close(hook.release)
+	require.NoError(t, <-done)
+}
+
+// memExtractor treats .zip files as a comma separated list of the files
+// they hold.
+type memExtractor struct {
+	*memDestination
+	extractions int
+}
+
+func (m *memExtractor) IsArchive(path string) bool {
+	return strings.HasSuffix(path, ".zip")
+}
+
+func (m *memExtractor) Extract(path string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.extractions++
+
+	var files []string
+	for _, name := range strings.Split(string(m.files[path]), ",") {
+		file := filepath.Join(filepath.Dir(path), name)
+		if _, ok := m.files[file]; ok {
+			continue
+		}
+		m.files[file] = []byte("extracted")
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func TestProcessExtractsArchives(t *testing.T) {
+	src := newMemSource(map[string]string{"/release/a.zip": "a.txt,b.txt", "/other.txt": "other"})
+	dst := &memExtractor{memDestination: newMemDestination(nil)}
+	db := newMemDatabase()
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger(), WithArchiveExtraction())
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{
+		"/release/a.zip": "a.txt,b.txt",
+		"/release/a.txt": "extracted",
+		"/release/b.txt": "extracted",
+		"/other.txt":     "other",
+	}, dst.contents())
+
+	// the extracted files are kept, and not extracted again
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"skip": 4}, plan.Counts())
+
+	require.NoError(t, p.Execute(plan))
+	assert.Equal(t, 1, dst.extractions)
+
+	// they go along with the archive
+	delete(src.files, "/release/a.zip")
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{"/other.txt": "other"}, dst.contents())
+}
+
+// memLinker is a memDestination that keeps symlinks apart from files.
+type memLinker struct {
+	*memDestination
+	links map[string]string
+}
+
+func (m *memLinker) GetAllFiles(path string) (*SizeSet, error) {
+	files, err := m.memDestination.GetAllFiles(path)
+	for link, target := range m.links {
+		files.SetInfo(link, FileInfo{Link: target})
+	}
+	return files, err
+}
+
+func (m *memLinker) Delete(path string) error {
+	delete(m.links, path)
+	return m.memDestination.Delete(path)
+}
+
+func (m *memLinker) Symlink(path, target string) error {
+	m.links[path] = target
+	return nil
+}
+
+func TestProcessRecreatesLinks(t *testing.T) {
+	src := newMemSource(map[string]string{"/complete/a.mkv": "a"})
+	src.links = map[string]string{"/latest.mkv": "complete/a.mkv", "/sorted": "complete"}
+	dst := &memLinker{memDestination: newMemDestination(nil), links: map[string]string{"/sorted": "old"}}
+	db := newMemDatabase()
+
+	p := BuildProcessor(src, db, nil, dst, newTestLogger())
+	require.NoError(t, p.Process("/"))
+	assert.Equal(t, map[string]string{"/complete/a.mkv": "a"}, dst.contents())
+	assert.Equal(t, map[string]string{"/latest.mkv": "complete/a.mkv", "/sorted": "complete"}, dst.links)
+	assert.True(t, db.files.Has("/latest.mkv"))
+
+	plan, err := p.Plan("/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"skip": 3}, plan.Counts())
+}
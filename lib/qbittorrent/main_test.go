@@ -0,0 +1,97 @@
+package qbittorrent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeServer serves the parts of the qbittorrent api that are used, and
+// expires the session after every listing of torrents.
+func newFakeServer(t *testing.T) (*httptest.Server, *int) {
+	logins := new(int)
+	session := ""
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+			w.Write([]byte("Fails."))
+			return
+		}
+
+		*logins++
+		session = "session"
+		http.SetCookie(w, &http.Cookie{Name: "SID", Value: session, Path: "/"})
+		w.Write([]byte("Ok."))
+	})
+
+	authed := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie("SID")
+			if err != nil || session == "" || cookie.Value != session {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			handler(w, r)
+		}
+	}
+
+	mux.HandleFunc("/api/v2/torrents/info", authed(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]torrentInfo{{Hash: "abc"}, {Hash: "def"}})
+	}))
+	mux.HandleFunc("/api/v2/torrents/files", authed(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("hash") {
+		case "abc":
+			json.NewEncoder(w).Encode([]fileInfo{{"a/done.mkv", 1}, {"a/partial.mkv", 0.5}})
+		case "def":
+			json.NewEncoder(w).Encode([]fileInfo{{"b.mkv", 1}})
+			session = ""
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, logins
+}
+
+func newClient(t *testing.T, server *httptest.Server, password string) *QBittorrent {
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	u.Scheme = "qbittorrent"
+	u.User = url.UserPassword("admin", password)
+
+	client, err := New(logrus.New(), u)
+	require.NoError(t, err)
+	return client
+}
+
+func TestFiles(t *testing.T) {
+	server, logins := newFakeServer(t)
+	client := newClient(t, server, "secret")
+
+	files, err := client.Files()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a/done.mkv": true, "a/partial.mkv": false, "b.mkv": true}, files)
+	assert.Equal(t, 1, *logins)
+
+	// logs in again once the session expires
+	_, err = client.Files()
+	require.NoError(t, err)
+	assert.Equal(t, 2, *logins)
+}
+
+func TestBadLogin(t *testing.T) {
+	server, _ := newFakeServer(t)
+	client := newClient(t, server, "wrong")
+
+	_, err := client.Files()
+	assert.ErrorContains(t, err, "Fails.")
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"github.com/pkg/errors"
+)
+
+// planRetention decides what happens to a recorded file when removed files
+// are being kept, and returns false if the file is still on the source and
+// was never removed from it.
+func (p *Processor) planRetention(file string, key FileStatusKey) (NamedAction, string, bool) {
+	removedAt, err := p.db.GetTombstone(file)
+	if err != nil {
+		p.log.WithField("file", file).WithError(err).Error("failed to get tombstone")
+		return NamedAction{skipFile, "skip"}, "missing tombstone", true
+	}
+
+	switch {
+	case removedAt.IsZero() && key.HasRemote:
+		return NamedAction{}, "", false
+	case removedAt.IsZero():
+		return NamedAction{tombstoneFile, "tombstone"}, "removed from source", true
+	case p.keepFor > 0 && p.now().Sub(removedAt) >= p.keepFor:
+		return NamedAction{deleteFile, "delete"}, "retention expired", true
+	default:
+		return NamedAction{skipFile, "skip"}, "removed from source", true
+	}
+}
+
+// tombstoneFile records when path was removed from the source, so the local
+// copy is kept and isn't downloaded again if it comes back.
+func tombstoneFile(_ FileStatusKey, p *Processor, path string) error {
+	p.log.WithField("path", path).Info("removed from source, keeping local file")
+
+	if err := p.db.RecordTombstone(path, p.now()); err != nil {
+		return errors.Wrapf(err, "failed to record tombstone for %s", path)
+	}
+
+	return nil
+}
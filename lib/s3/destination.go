@@ -0,0 +1,125 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/pkg/errors"
+)
+
+// GetPartialSize always returns 0, since unfinished multipart uploads are
+// aborted rather than resumed.
+func (b *Bucket) GetPartialSize(string) (int64, error) {
+	return 0, nil
+}
+
+func (b *Bucket) Exists(path string) (bool, error) {
+	_, err := b.core.StatObject(context.Background(), b.bucket, b.toKey(path), minio.StatObjectOptions{})
+	if err == nil {
+		return true, nil
+	}
+
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	return false, errors.Wrapf(err, "failed to stat %s", path)
+}
+
+// Write uploads fp in one request if it fits in a single part, and as a
+// multipart upload otherwise.
+func (b *Bucket) Write(path string, offset int64, fp io.ReadCloser) (int64, error) {
+	if offset != 0 {
+		return 0, errors.New("s3 does not support resuming uploads")
+	}
+
+	// checksums have to come from the new object
+	b.forget(path)
+
+	ctx := context.Background()
+	key := b.toKey(path)
+	buffer := make([]byte, b.partSize)
+
+	n, err := io.ReadFull(fp, buffer)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = b.core.PutObject(ctx, b.bucket, key, bytes.NewReader(buffer[:n]), int64(n), md5Base64(buffer[:n]), "", minio.PutObjectOptions{})
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to upload %s", path)
+		}
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read %s", path)
+	}
+
+	uploadID, err := b.core.NewMultipartUpload(ctx, b.bucket, key, minio.PutObjectOptions{})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to start uploading %s", path)
+	}
+
+	written, err := b.writeParts(ctx, key, uploadID, fp, buffer, n)
+	if err != nil {
+		if abortErr := b.core.AbortMultipartUpload(ctx, b.bucket, key, uploadID); abortErr != nil {
+			b.logger.WithError(abortErr).WithField("path", path).Warn("failed to abort upload")
+		}
+		return written, errors.Wrapf(err, "failed to upload %s", path)
+	}
+
+	return written, nil
+}
+
+// writeParts uploads the n bytes already in buffer, then the rest of fp a
+// part at a time, and returns how many bytes were uploaded.
+func (b *Bucket) writeParts(ctx context.Context, key, uploadID string, fp io.Reader, buffer []byte, n int) (int64, error) {
+	var parts []minio.CompletePart
+	var written int64
+
+	for n > 0 {
+		part := buffer[:n]
+		opts := minio.PutObjectPartOptions{Md5Base64: md5Base64(part)}
+
+		uploaded, err := b.core.PutObjectPart(ctx, b.bucket, key, uploadID, len(parts)+1, bytes.NewReader(part), int64(n), opts)
+		if err != nil {
+			return written, errors.Wrapf(err, "failed to upload part %d", len(parts)+1)
+		}
+		parts = append(parts, minio.CompletePart{PartNumber: uploaded.PartNumber, ETag: uploaded.ETag})
+		written += int64(n)
+
+		n, err = io.ReadFull(fp, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return written, errors.Wrap(err, "failed to read file")
+		}
+	}
+
+	if _, err := b.core.CompleteMultipartUpload(ctx, b.bucket, key, uploadID, parts, minio.PutObjectOptions{}); err != nil {
+		return written, errors.Wrap(err, "failed to complete upload")
+	}
+
+	return written, nil
+}
+
+func md5Base64(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (b *Bucket) Delete(path string) error {
+	b.forget(path)
+
+	if err := b.core.RemoveObject(context.Background(), b.bucket, b.toKey(path), minio.RemoveObjectOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to delete %s", path)
+	}
+
+	return nil
+}
+
+// CleanDirectories does nothing, since S3 folders only exist while there
+// are objects in them.
+func (b *Bucket) CleanDirectories(string) error {
+	return nil
+}
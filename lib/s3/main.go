@@ -0,0 +1,267 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const (
+	defaultEndpoint = "s3.amazonaws.com"
+
+	// defaultPartSize is how much of a file is uploaded per request. S3
+	// allows at most 10,000 parts, so this handles files up to ~160GB.
+	defaultPartSize = 16 << 20
+
+	pageSize = 1000
+)
+
+// New reads from and writes to the bucket in url, which looks like
+// s3://access:secret@bucket/prefix. Credentials come from the AWS_ or
+// MINIO_ environment variables if the url has none. Other servers are set
+// with an endpoint query parameter, like ?endpoint=http://localhost:9000,
+// and a region with ?region=, or AWS_REGION.
+func New(url *url.URL, filter *lib.Filter, logger logrus.FieldLogger) (*Bucket, error) {
+	if url.Scheme != "s3" {
+		return nil, fmt.Errorf("unknown schema: %s", url.Scheme)
+	}
+	if url.Host == "" {
+		return nil, errors.New("must define a bucket")
+	}
+
+	query := url.Query()
+
+	endpoint, secure, err := parseEndpoint(query.Get("endpoint"))
+	if err != nil {
+		return nil, err
+	}
+
+	region := query.Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+
+	var creds *credentials.Credentials
+	if url.User != nil {
+		secret, _ := url.User.Password()
+		creds = credentials.NewStaticV4(url.User.Username(), secret, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+		})
+	}
+
+	core, err := minio.NewCore(endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: secure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create client")
+	}
+
+	return &Bucket{
+		core:     core,
+		bucket:   url.Host,
+		prefix:   strings.Trim(url.Path, "/"),
+		filter:   filter,
+		logger:   logger,
+		partSize: defaultPartSize,
+		etags:    make(map[string]string),
+	}, nil
+}
+
+// parseEndpoint splits an endpoint like http://localhost:9000 into the
+// host and whether it uses https. Endpoints without a scheme use https.
+func parseEndpoint(endpoint string) (string, bool, error) {
+	if endpoint == "" {
+		return defaultEndpoint, true, nil
+	}
+
+	if !strings.Contains(endpoint, "://") {
+		return endpoint, true, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", false, errors.Wrap(err, "failed to parse endpoint")
+	}
+
+	switch u.Scheme {
+	case "http":
+		return u.Host, false, nil
+	case "https":
+		return u.Host, true, nil
+	default:
+		return "", false, fmt.Errorf("unknown endpoint schema: %s", u.Scheme)
+	}
+}
+
+type Bucket struct {
+	core     *minio.Core
+	bucket   string
+	prefix   string
+	filter   *lib.Filter
+	logger   logrus.FieldLogger
+	partSize int64
+
+	// etags are remembered from the last listing, so checksums don't need
+	// another request.
+	lock  sync.Mutex
+	etags map[string]string
+}
+
+var (
+	_ lib.Source      = new(Bucket)
+	_ lib.Destination = new(Bucket)
+	_ lib.Checksummer = new(Bucket)
+	_ lib.Stater      = new(Bucket)
+)
+
+// toKey returns the object key for path.
+func (b *Bucket) toKey(p string) string {
+	return strings.TrimPrefix(path.Join(b.prefix, p), "/")
+}
+
+// GetAllFiles lists every object under path. S3 has no directories, so
+// this pages through a flat listing instead of walking.
+func (b *Bucket) GetAllFiles(rootPath string) (*lib.SizeSet, error) {
+	result := lib.NewSizeSet()
+	etags := make(map[string]string)
+
+	prefix := b.toKey(rootPath)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	var token string
+	for {
+		page, err := b.core.ListObjectsV2(b.bucket, prefix, "", token, "", pageSize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list %s", rootPath)
+		}
+
+		for _, object := range page.Contents {
+			rel := strings.TrimPrefix(object.Key, prefix)
+
+			// folders made by consoles are empty objects ending in a slash
+			if rel == "" || strings.HasSuffix(rel, "/") || lib.IsPartialPath(rel) {
+				continue
+			}
+
+			info := lib.FileInfo{Size: object.Size, ModTime: object.LastModified}
+			if b.skipDir(path.Dir(rel)) || !b.filter.Match(rel, info) {
+				continue
+			}
+
+			fullPath := path.Join("/", rootPath, rel)
+			result.SetInfo(fullPath, info)
+			etags[fullPath] = strings.Trim(object.ETag, `"`)
+		}
+
+		if !page.IsTruncated {
+			break
+		}
+		if page.NextContinuationToken == "" {
+			return nil, fmt.Errorf("failed to list %s: truncated listing has no continuation token", rootPath)
+		}
+		token = page.NextContinuationToken
+	}
+
+	b.lock.Lock()
+	b.etags = etags
+	b.lock.Unlock()
+
+	return result, nil
+}
+
+// skipDir reports whether the filter skips dir or any folder above it.
+func (b *Bucket) skipDir(dir string) bool {
+	for ; dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if b.filter.SkipDir(dir) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *Bucket) Read(path string, offset int64) (io.ReadCloser, error) {
+	var opts minio.GetObjectOptions
+	if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, errors.Wrap(err, "failed to set range")
+		}
+	}
+
+	fp, _, _, err := b.core.GetObject(context.Background(), b.bucket, b.toKey(path), opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s", path)
+	}
+
+	return fp, nil
+}
+
+func (b *Bucket) Stat(path string) (lib.FileInfo, error) {
+	object, err := b.core.StatObject(context.Background(), b.bucket, b.toKey(path), minio.StatObjectOptions{})
+	if err != nil {
+		return lib.FileInfo{}, errors.Wrapf(err, "failed to stat %s", path)
+	}
+
+	return lib.FileInfo{Size: object.Size, ModTime: object.LastModified}, nil
+}
+
+// Checksum returns the md5 of path from its ETag. Objects uploaded in
+// parts, or encrypted with KMS, have ETags that aren't md5s.
+func (b *Bucket) Checksum(path string, algorithms ...string) (lib.Checksum, error) {
+	supported := false
+	for _, algorithm := range algorithms {
+		supported = supported || algorithm == lib.MD5
+	}
+	if !supported {
+		return lib.Checksum{}, lib.ErrChecksumUnsupported
+	}
+
+	b.lock.Lock()
+	etag, ok := b.etags[path]
+	b.lock.Unlock()
+
+	if !ok {
+		object, err := b.core.StatObject(context.Background(), b.bucket, b.toKey(path), minio.StatObjectOptions{})
+		if err != nil {
+			return lib.Checksum{}, errors.Wrapf(err, "failed to stat %s", path)
+		}
+		etag = strings.Trim(object.ETag, `"`)
+	}
+
+	if len(etag) != 32 || strings.Contains(etag, "-") {
+		return lib.Checksum{}, lib.ErrChecksumUnsupported
+	}
+
+	return lib.Checksum{Algorithm: lib.MD5, Value: strings.ToLower(etag)}, nil
+}
+
+// forget drops the listed etag of path, once it's been overwritten or
+// deleted.
+func (b *Bucket) forget(path string) {
+	b.lock.Lock()
+	delete(b.etags, path)
+	b.lock.Unlock()
+}
+
+func (b *Bucket) Close() error {
+	return nil
+}
@@ -0,0 +1,339 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const (
+	testBucket    = "media"
+	testAccessKey = "ftpsync"
+	testSecretKey = "hunter22"
+)
+
+var modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+type object struct {
+	data []byte
+	etag string
+}
+
+type listObject struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified string
+}
+
+type listResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	IsTruncated           bool
+	NextContinuationToken string       `xml:",omitempty"`
+	Contents              []listObject `xml:"Contents"`
+}
+
+// fakeServer is just enough of the S3 api to list, read, upload and delete
+// objects, with paths like /bucket/key.
+type fakeServer struct {
+	lock    sync.Mutex
+	objects map[string]object
+	uploads map[string]map[int][]byte
+	lists   int
+}
+
+func newFakeServer(t *testing.T, objects map[string]string) (*fakeServer, *url.URL) {
+	fake := &fakeServer{objects: map[string]object{}, uploads: map[string]map[int][]byte{}}
+	for key, content := range objects {
+		fake.put(key, []byte(content))
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	u := &url.URL{
+		Scheme:   "s3",
+		User:     url.UserPassword(testAccessKey, testSecretKey),
+		Host:     testBucket,
+		Path:     "/backups",
+		RawQuery: url.Values{"endpoint": {server.URL}, "region": {"us-east-1"}}.Encode(),
+	}
+
+	return fake, u
+}
+
+func (s *fakeServer) put(key string, data []byte) {
+	sum := md5.Sum(data)
+	s.objects[key] = object{data, hex.EncodeToString(sum[:])}
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential="+testAccessKey+"/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == "GET" && key == "":
+		s.list(w, query)
+	case r.Method == "GET" || r.Method == "HEAD":
+		obj, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		http.ServeContent(w, r, key, modTime, bytes.NewReader(obj.data))
+	case r.Method == "POST" && query.Has("uploads"):
+		uploadID := fmt.Sprintf("upload-%d", len(s.uploads)+1)
+		s.uploads[uploadID] = map[int][]byte{}
+		_, _ = fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, uploadID)
+	case r.Method == "PUT" && query.Has("uploadId"):
+		parts, ok := s.uploads[query.Get("uploadId")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = readBody(r)
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, number))
+	case r.Method == "POST" && query.Has("uploadId"):
+		parts := s.uploads[query.Get("uploadId")]
+		delete(s.uploads, query.Get("uploadId"))
+
+		var data []byte
+		for number := 1; number <= len(parts); number++ {
+			data = append(data, parts[number]...)
+		}
+		s.objects[key] = object{data, fmt.Sprintf("abc-%d", len(parts))}
+		_, _ = fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>abc</ETag></CompleteMultipartUploadResult>", bucket, key)
+	case r.Method == "PUT":
+		s.put(key, readBody(r))
+		w.Header().Set("ETag", `"`+s.objects[key].etag+`"`)
+	case r.Method == "DELETE" && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// list returns objects a page at a time, with the token being the index of
+// the next page.
+func (s *fakeServer) list(w http.ResponseWriter, query url.Values) {
+	s.lists++
+
+	prefix := query.Get("prefix")
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	// smaller than any real page, so listings need several
+	const pageSize = 2
+	start, _ := strconv.Atoi(query.Get("continuation-token"))
+	end := min(start+pageSize, len(keys))
+
+	result := listResult{Name: testBucket, Prefix: prefix, KeyCount: end - start}
+	for _, key := range keys[start:end] {
+		obj := s.objects[key]
+		result.Contents = append(result.Contents, listObject{
+			Key:          key,
+			Size:         int64(len(obj.data)),
+			ETag:         `"` + obj.etag + `"`,
+			LastModified: modTime.Format(time.RFC3339),
+		})
+	}
+	if end < len(keys) {
+		result.IsTruncated = true
+		result.NextContinuationToken = strconv.Itoa(end)
+	}
+
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// readBody reads an upload, which is signed in chunks over http.
+func readBody(r *http.Request) []byte {
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		data, _ := io.ReadAll(r.Body)
+		return data
+	}
+
+	var data []byte
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return data
+		}
+		size, _ := strconv.ParseInt(strings.Split(header, ";")[0], 16, 64)
+		if size == 0 {
+			return data
+		}
+		chunk := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, chunk); err != nil {
+			return data
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func newBucket(t *testing.T, u *url.URL, filter *lib.Filter) *Bucket {
+	bucket, err := New(u, filter, logrus.New())
+	require.NoError(t, err)
+	return bucket
+}
+
+func TestSource(t *testing.T) {
+	fake, u := newFakeServer(t, map[string]string{
+		"backups/movies/a.mkv":                  "hello world",
+		"backups/movies/sample/b.mkv":           "sample",
+		"backups/movies/":                       "",
+		"backups/tv/c.mkv":                      "hi",
+		"backups/tv/.d.mkv" + lib.PartialSuffix: "partial",
+		"other/e.mkv":                           "elsewhere",
+	})
+
+	filter, err := lib.NewFilter(lib.FilterOptions{Exclude: []string{"**/sample"}})
+	require.NoError(t, err)
+
+	bucket := newBucket(t, u, filter)
+	defer bucket.Close()
+
+	files, err := bucket.GetAllFiles("/")
+	require.NoError(t, err)
+	assert.Equal(t, 3, fake.lists)
+	assert.Equal(t, 2, files.Len())
+
+	info, ok := files.GetInfo("/movies/a.mkv")
+	require.True(t, ok)
+	assert.Equal(t, lib.FileInfo{Size: 11, ModTime: modTime}, info)
+	assert.True(t, files.Has("/tv/c.mkv"))
+
+	checksum, err := bucket.Checksum("/movies/a.mkv", lib.SHA256, lib.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, lib.Checksum{Algorithm: lib.MD5, Value: "5eb63bbbe01eeed093cb22bb8f5acdc3"}, checksum)
+
+	_, err = bucket.Checksum("/movies/a.mkv", lib.SHA256)
+	assert.ErrorIs(t, err, lib.ErrChecksumUnsupported)
+
+	files, err = bucket.GetAllFiles("/tv")
+	require.NoError(t, err)
+	assert.Equal(t, 1, files.Len())
+	assert.True(t, files.Has("/tv/c.mkv"))
+
+	for offset, expected := range map[int64]string{0: "hello world", 6: "world"} {
+		fp, err := bucket.Read("/movies/a.mkv", offset)
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(fp)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+		require.NoError(t, fp.Close())
+	}
+}
+
+func TestDestination(t *testing.T) {
+	fake, u := newFakeServer(t, map[string]string{"backups/old/gone.txt": "gone"})
+
+	bucket := newBucket(t, u, nil)
+	bucket.partSize = 4
+
+	size, err := bucket.Write("/a/small.txt", 0, io.NopCloser(strings.NewReader("hi")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), size)
+
+	size, err = bucket.Write("/a/big.txt", 0, io.NopCloser(strings.NewReader("hello world")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), size)
+	assert.Equal(t, "hello world", string(fake.objects["backups/a/big.txt"].data))
+	assert.Equal(t, "abc-3", fake.objects["backups/a/big.txt"].etag)
+	assert.Empty(t, fake.uploads)
+
+	_, err = bucket.Write("/a/big.txt", 2, io.NopCloser(strings.NewReader("llo")))
+	assert.Error(t, err)
+
+	// overwriting a listed object replaces its checksum
+	files, err := bucket.GetAllFiles("/")
+	require.NoError(t, err)
+	assert.True(t, files.Has("/a/small.txt"))
+
+	_, err = bucket.Write("/a/small.txt", 0, io.NopCloser(strings.NewReader("bye")))
+	require.NoError(t, err)
+	checksum, err := bucket.Checksum("/a/small.txt", lib.MD5)
+	require.NoError(t, err)
+	assert.Equal(t, fake.objects["backups/a/small.txt"].etag, checksum.Value)
+
+	// multipart etags aren't md5s
+	_, err = bucket.Checksum("/a/big.txt", lib.MD5)
+	assert.ErrorIs(t, err, lib.ErrChecksumUnsupported)
+
+	exists, err := bucket.Exists("/a/small.txt")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, bucket.Delete("/old/gone.txt"))
+	exists, err = bucket.Exists("/old/gone.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, bucket.CleanDirectories("/"))
+	assert.Len(t, fake.objects, 2)
+}
+
+func TestNew(t *testing.T) {
+	testCases := map[string]string{
+		"wrong scheme":   "ftp://bucket/prefix",
+		"missing bucket": "s3:///prefix",
+		"bad endpoint":   "s3://bucket/prefix?endpoint=gopher://localhost",
+	}
+
+	for name, text := range testCases {
+		t.Run(name, func(t *testing.T) {
+			u, err := url.Parse(text)
+			require.NoError(t, err)
+
+			_, err = New(u, nil, logrus.New())
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+var (
+	_ lib.Destination   = new(source)
+	_ lib.ModTimeSetter = new(source)
+	_ lib.Stater        = new(source)
+)
+
+func (s *source) GetPartialSize(path string) (int64, error) {
+	info, err := s.client.Stat(lib.PartialPath(s.toRemotePath(path)))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "failed to check partial file")
+	}
+
+	return info.Size(), nil
+}
+
+func (s *source) Exists(path string) (bool, error) {
+	_, err := s.client.Stat(s.toRemotePath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "failed to check remote path")
+	}
+
+	return true, nil
+}
+
+func (s *source) Delete(path string) error {
+	path = s.toRemotePath(path)
+	if err := s.client.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "failed to delete file")
+	}
+
+	if err := s.client.Remove(lib.PartialPath(path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "failed to delete partial file")
+	}
+
+	return nil
+}
+
+func (s *source) Write(path string, offset int64, fp io.ReadCloser) (int64, error) {
+	path = s.toRemotePath(path)
+	partial := lib.PartialPath(path)
+
+	if err := s.client.MkdirAll(filepath.Dir(path)); err != nil {
+		return 0, errors.Wrapf(err, "failed to create %s", filepath.Dir(path))
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := s.client.OpenFile(partial, flags)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to open partial file")
+	}
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return 0, errors.Wrap(err, "failed to seek in partial file")
+	}
+
+	written, err := file.ReadFrom(fp)
+	if err != nil {
+		file.Close()
+		return written, fmt.Errorf("failed to upload file (wrote %d bytes): %w", written, err)
+	}
+
+	if err = file.Close(); err != nil {
+		return written, errors.Wrap(err, "failed to close partial file")
+	}
+
+	// the partial file holds everything written, so say so
+	if err = s.client.PosixRename(partial, path); err != nil {
+		return written, errors.Wrap(err, "failed to rename partial file to final destination")
+	}
+
+	return written, nil
+}
+
+func (s *source) Stat(path string) (lib.FileInfo, error) {
+	info, err := s.client.Stat(s.toRemotePath(path))
+	if err != nil {
+		return lib.FileInfo{}, errors.Wrap(err, "failed to stat file")
+	}
+
+	return lib.FileInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *source) SetModTime(path string, modTime time.Time) error {
+	if err := s.client.Chtimes(s.toRemotePath(path), modTime, modTime); err != nil {
+		return errors.Wrap(err, "failed to set modification time")
+	}
+
+	return nil
+}
+
+// cleanDirectories deletes every empty directory under path, and path itself
+// if it's empty too, unless it's the root of the sync.
+func (s *source) cleanDirectories(path string, isRoot bool) (isDeleted bool, err error) {
+	entries, err := s.client.ReadDir(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, errors.Wrapf(err, "failed to list %s", path)
+	}
+
+	var hasChildren bool
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirPath := filepath.Join(path, entry.Name())
+			wasDeleted, err := s.cleanDirectories(dirPath, false)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to clean %s", dirPath)
+			}
+			if wasDeleted {
+				continue
+			}
+		}
+
+		hasChildren = true
+	}
+
+	if hasChildren || isRoot {
+		return false, nil
+	}
+
+	if err = s.client.RemoveDirectory(path); err != nil {
+		return false, errors.Wrapf(err, "failed to remove %s", path)
+	}
+
+	return true, nil
+}
+
+func (s *source) CleanDirectories(path string) error {
+	_, err := s.cleanDirectories(s.toRemotePath(path), true)
+
+	return err
+}
@@ -0,0 +1,231 @@
+package sftp
+
+import (
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const defaultPort = "22"
+
+// New connects to an SSH server and opens an sftp session. Authentication
+// uses the password in the url and/or a private key passed as the `key`
+// query parameter (with an optional `passphrase`). The server's host key is
+// verified against `known_hosts`, which defaults to ~/.ssh/known_hosts.
+func New(url *url.URL, filter *lib.Filter, links lib.LinkPolicy, logger logrus.FieldLogger) (lib.Source, error) {
+	src, err := dial(url, filter, links, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return src, nil
+}
+
+// NewDestination connects to the server for writing, like New.
+func NewDestination(url *url.URL, filter *lib.Filter, links lib.LinkPolicy, logger logrus.FieldLogger) (lib.Destination, error) {
+	dst, err := dial(url, filter, links, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return dst, nil
+}
+
+func dial(url *url.URL, filter *lib.Filter, links lib.LinkPolicy, logger logrus.FieldLogger) (*source, error) {
+	config, err := buildClientConfig(url)
+	if err != nil {
+		return nil, err
+	}
+
+	host := url.Host
+	if url.Port() == "" {
+		host = net.JoinHostPort(url.Hostname(), defaultPort)
+	}
+
+	conn, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial ssh server")
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "failed to start sftp subsystem")
+	}
+
+	return &source{conn: conn, client: client, root: url.Path, filter: filter, links: links, logger: logger}, nil
+}
+
+func buildClientConfig(url *url.URL) (*ssh.ClientConfig, error) {
+	var auth []ssh.AuthMethod
+
+	query := url.Query()
+
+	if keyPath := query.Get("key"); keyPath != "" {
+		signer, err := readPrivateKey(keyPath, query.Get("passphrase"))
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if password, ok := url.User.Password(); ok {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	if len(auth) == 0 {
+		return nil, errors.New("must provide a password or private key")
+	}
+
+	knownHostsPath := query.Get("known_hosts")
+	if knownHostsPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to find home directory")
+		}
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", knownHostsPath)
+	}
+
+	return &ssh.ClientConfig{
+		User:            url.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+func readPrivateKey(path, passphrase string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+
+	var signer ssh.Signer
+	if passphrase == "" {
+		signer, err = ssh.ParsePrivateKey(data)
+	} else {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+
+	return signer, nil
+}
+
+type source struct {
+	root   string
+	conn   *ssh.Client
+	client *sftp.Client
+	filter *lib.Filter
+	links  lib.LinkPolicy
+	logger logrus.FieldLogger
+}
+
+var (
+	_ lib.Source       = new(source)
+	_ lib.LinkResolver = new(source)
+)
+
+func (s *source) GetAllFiles(path string) (*lib.SizeSet, error) {
+	return lib.WalkLister(s, path, s.filter, s.links)
+}
+
+func (s *source) toRemotePath(path string) string {
+	path = strings.TrimLeft(path, "/")
+	path = filepath.Join(s.root, path)
+	return path
+}
+
+func (s *source) List(path string) (lib.ListResult, error) {
+	result := lib.NewListResult()
+
+	rootPath := s.toRemotePath(path)
+
+	entries, err := s.client.ReadDir(rootPath)
+	if err != nil {
+		return result, errors.Wrapf(err, "failed to walk %s", path)
+	}
+
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir():
+			result.Folders = append(result.Folders, entry.Name())
+		case entry.Mode().IsRegular():
+			if lib.IsPartialPath(entry.Name()) {
+				continue
+			}
+			result.Files[entry.Name()] = lib.FileInfo{Size: entry.Size(), ModTime: entry.ModTime()}
+		case entry.Mode()&os.ModeSymlink != 0:
+			if s.links == lib.LinksSkip {
+				continue
+			}
+
+			target, err := s.client.ReadLink(filepath.Join(rootPath, entry.Name()))
+			if err != nil {
+				s.logger.WithError(err).WithField("path", filepath.Join(path, entry.Name())).Warning("failed to read link, skipping it")
+				continue
+			}
+			result.Links[entry.Name()] = target
+		}
+	}
+
+	return result, nil
+}
+
+func (s *source) ServerPath(path string) string {
+	return s.toRemotePath(path)
+}
+
+func (s *source) StatLink(path string) (lib.FileInfo, bool, error) {
+	info, err := s.client.Stat(s.toRemotePath(path))
+	if err != nil {
+		return lib.FileInfo{}, false, errors.Wrapf(err, "failed to follow %s", path)
+	}
+
+	if info.IsDir() {
+		return lib.FileInfo{}, true, nil
+	}
+
+	return lib.FileInfo{Size: info.Size(), ModTime: info.ModTime()}, false, nil
+}
+
+func (s *source) Read(path string, offset int64) (io.ReadCloser, error) {
+	path = s.toRemotePath(path)
+
+	fp, err := s.client.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open file")
+	}
+
+	if _, err = fp.Seek(offset, io.SeekStart); err != nil {
+		fp.Close()
+		return nil, errors.Wrapf(err, "failed to seek to %d", offset)
+	}
+
+	return fp, nil
+}
+
+func (s *source) Close() error {
+	if err := s.client.Close(); err != nil {
+		s.conn.Close()
+		return errors.Wrap(err, "failed to close sftp client")
+	}
+
+	return s.conn.Close()
+}
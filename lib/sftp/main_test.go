@@ -0,0 +1,293 @@
+package sftp
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const (
+	testUser     = "ftpsync"
+	testPassword = "hunter2"
+)
+
+type testServer struct {
+	addr           string
+	knownHostsPath string
+	clientKeyPath  string
+}
+
+func newSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	return signer, key
+}
+
+func startServer(t *testing.T) testServer {
+	tempDir := t.TempDir()
+
+	hostSigner, _ := newSigner(t)
+	clientSigner, clientKey := newSigner(t)
+
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	require.NoError(t, err)
+	clientKeyPath := filepath.Join(tempDir, "id_ed25519")
+	require.NoError(t, os.WriteFile(clientKeyPath, pem.EncodeToMemory(block), 0o600))
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == testUser && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("bad password for %s", conn.User())
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == testUser && string(key.Marshal()) == string(clientSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	knownHostsPath := filepath.Join(tempDir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey())
+	require.NoError(t, os.WriteFile(knownHostsPath, []byte(line+"\n"), 0o600))
+
+	return testServer{addr, knownHostsPath, clientKeyPath}
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for req := range channelRequests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					return
+				}
+				_ = server.Serve()
+				_ = server.Close()
+				return
+			}
+		}()
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestSource(t *testing.T) {
+	server := startServer(t)
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.txt"), "hello")
+	writeFile(t, filepath.Join(root, "sub", "b.txt"), "hello world")
+	require.NoError(t, os.Symlink(filepath.Join(root, "a.txt"), filepath.Join(root, "link.txt")))
+
+	testCases := map[string]url.Values{
+		"password": {
+			"known_hosts": {server.knownHostsPath},
+		},
+		"private key": {
+			"known_hosts": {server.knownHostsPath},
+			"key":         {server.clientKeyPath},
+		},
+	}
+
+	for name, query := range testCases {
+		t.Run(name, func(t *testing.T) {
+			u := &url.URL{
+				Scheme:   "sftp",
+				Host:     server.addr,
+				Path:     root,
+				RawQuery: query.Encode(),
+			}
+			if query.Get("key") == "" {
+				u.User = url.UserPassword(testUser, testPassword)
+			} else {
+				u.User = url.User(testUser)
+			}
+
+			src, err := New(u, nil, lib.LinksSkip, logrus.New())
+			require.NoError(t, err)
+			defer src.Close()
+
+			files, err := src.GetAllFiles("/")
+			require.NoError(t, err)
+			assert.Equal(t, 2, files.Len())
+
+			size, ok := files.Get("/sub/b.txt")
+			require.True(t, ok)
+			assert.Equal(t, int64(11), size)
+
+			fp, err := src.Read("/sub/b.txt", 0)
+			require.NoError(t, err)
+			defer fp.Close()
+
+			data, err := io.ReadAll(fp)
+			require.NoError(t, err)
+			assert.Equal(t, "hello world", string(data))
+
+			fp, err = src.Read("/sub/b.txt", 6)
+			require.NoError(t, err)
+			defer fp.Close()
+
+			data, err = io.ReadAll(fp)
+			require.NoError(t, err)
+			assert.Equal(t, "world", string(data))
+		})
+	}
+}
+
+func TestDestination(t *testing.T) {
+	server := startServer(t)
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "old", "gone.txt"), "gone")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "empty", "nested"), 0o755))
+
+	u := &url.URL{
+		Scheme:   "sftp",
+		User:     url.UserPassword(testUser, testPassword),
+		Host:     server.addr,
+		Path:     root,
+		RawQuery: url.Values{"known_hosts": {server.knownHostsPath}}.Encode(),
+	}
+
+	dst, err := NewDestination(u, nil, lib.LinksSkip, logrus.New())
+	require.NoError(t, err)
+	defer dst.(io.Closer).Close()
+
+	size, err := dst.Write("/a/b.txt", 0, io.NopCloser(strings.NewReader("hel")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+
+	// an interrupted upload is resumed from the partial file
+	writeFile(t, lib.PartialPath(filepath.Join(root, "a", "c.txt")), "hel")
+	offset, err := dst.GetPartialSize("/a/c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), offset)
+
+	files, err := dst.GetAllFiles("/")
+	require.NoError(t, err)
+	assert.False(t, files.Has(lib.PartialPath("/a/c.txt")))
+
+	size, err = dst.Write("/a/c.txt", offset, io.NopCloser(strings.NewReader("lo")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), size)
+
+	data, err := os.ReadFile(filepath.Join(root, "a", "c.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// a directory in the way stops the rename, after the upload
+	writeFile(t, filepath.Join(root, "a", "d.txt", "in-the-way.txt"), "x")
+	size, err = dst.Write("/a/d.txt", 0, io.NopCloser(strings.NewReader("hello")))
+	require.Error(t, err)
+	assert.Equal(t, int64(5), size)
+	offset, err = dst.GetPartialSize("/a/d.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), offset)
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "a", "d.txt")))
+	require.NoError(t, os.Remove(lib.PartialPath(filepath.Join(root, "a", "d.txt"))))
+
+	exists, err := dst.Exists("/a/b.txt")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	require.NoError(t, dst.Delete("/old/gone.txt"))
+	exists, err = dst.Exists("/old/gone.txt")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	require.NoError(t, dst.CleanDirectories("/"))
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "a", entries[0].Name())
+}
+
+func TestUnknownHostKey(t *testing.T) {
+	server := startServer(t)
+
+	emptyKnownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(emptyKnownHosts, nil, 0o600))
+
+	u := &url.URL{
+		Scheme:   "sftp",
+		User:     url.UserPassword(testUser, testPassword),
+		Host:     server.addr,
+		Path:     "/",
+		RawQuery: url.Values{"known_hosts": {emptyKnownHosts}}.Encode(),
+	}
+
+	_, err := New(u, nil, lib.LinksSkip, logrus.New())
+	require.Error(t, err)
+}
+
+func TestMissingAuth(t *testing.T) {
+	u := &url.URL{Scheme: "sftp", User: url.User(testUser), Host: "127.0.0.1:1"}
+
+	_, err := New(u, nil, lib.LinksSkip, logrus.New())
+	require.Error(t, err)
+}
@@ -1,19 +1,35 @@
 package lib
 
+import "time"
+
+// FileInfo is what's known about a file besides its path. ModTime is zero
+// when the backend doesn't report modification times.
+type FileInfo struct {
+	Size    int64
+	ModTime time.Time
+	// Link is the target of a symlink that should be recreated, relative to
+	// the link. Links have no size or modification time.
+	Link string
+}
+
 func NewSizeSet() *SizeSet {
 	var set SizeSet
 
-	set.m = make(map[string]int64)
+	set.m = make(map[string]FileInfo)
 
 	return &set
 }
 
 type SizeSet struct {
-	m map[string]int64
+	m map[string]FileInfo
 }
 
 func (ss *SizeSet) Set(path string, size int64) {
-	ss.m[path] = size
+	ss.m[path] = FileInfo{Size: size}
+}
+
+func (ss *SizeSet) SetInfo(path string, info FileInfo) {
+	ss.m[path] = info
 }
 
 func (ss *SizeSet) Len() int {
@@ -26,8 +42,13 @@ func (ss *SizeSet) Has(path string) bool {
 }
 
 func (ss *SizeSet) Get(path string) (int64, bool) {
-	size, ok := ss.m[path]
-	return size, ok
+	info, ok := ss.m[path]
+	return info.Size, ok
+}
+
+func (ss *SizeSet) GetInfo(path string) (FileInfo, bool) {
+	info, ok := ss.m[path]
+	return info, ok
 }
 
 func (ss *SizeSet) ToSet() *Set {
@@ -3,6 +3,9 @@ package sqlite
 import (
 	"database/sql"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
@@ -17,6 +20,28 @@ CREATE TABLE IF NOT EXISTS files (
 )
 `
 
+// files that were extracted from an archive, which is also in files
+const createExtractedTable = `
+CREATE TABLE IF NOT EXISTS extracted (
+    path 	STRING 		NOT NULL 	PRIMARY KEY,
+    archive 	STRING 		NOT NULL
+)
+`
+
+// columns added after the files table was first released, which
+// CREATE TABLE IF NOT EXISTS won't add to existing databases
+var fileColumns = []struct{ name, definition string }{
+	{"hash_algorithm", "STRING"},
+	{"hash", "STRING"},
+	{"remote_mtime", "DATETIME"},
+	{"remote_size", "INTEGER"},
+	{"local_size", "INTEGER"},
+	{"local_mtime", "DATETIME"},
+	{"removed_at", "DATETIME"},
+	{"local_path", "STRING"},
+	{"extracted_at", "DATETIME"},
+}
+
 type database struct {
 	db *sql.DB
 }
@@ -29,18 +54,133 @@ func New(dbPath string) (lib.Database, error) {
 		return nil, errors.Wrapf(err, "failed to open %s", dbPath)
 	}
 
+	// sqlite only allows one writer, and every connection to :memory: is its
+	// own database, so funnel everything through a single connection
+	db.SetMaxOpenConns(1)
+
 	if _, err = db.Exec(createFilesTable); err != nil {
 		return nil, errors.Wrap(err, "failed to create files table")
 	}
 
+	if _, err = db.Exec(createExtractedTable); err != nil {
+		return nil, errors.Wrap(err, "failed to create extracted table")
+	}
+
+	if err = addMissingColumns(db); err != nil {
+		return nil, err
+	}
+
 	return &database{db}, nil
 }
 
+// NewReadOnly opens dbPath without creating or migrating it. A database that
+// doesn't exist yet is treated as empty, and one written by an older
+// version is refused, since it can't be migrated.
+func NewReadOnly(dbPath string) (lib.Database, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return New(":memory:")
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", dbPath))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", dbPath)
+	}
+	db.SetMaxOpenConns(1)
+
+	if err = checkSchema(db); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "%s needs migrating, run a sync first", dbPath)
+	}
+
+	return &database{db}, nil
+}
+
+// checkSchema returns an error if db is missing anything New would add.
+func checkSchema(db *sql.DB) error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'extracted'`).Scan(&count); err != nil {
+		return errors.Wrap(err, "failed to read tables")
+	}
+	if count == 0 {
+		return errors.New("missing extracted table")
+	}
+
+	existing, err := getFileColumns(db)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range fileColumns {
+		if !existing.Has(column.name) {
+			return fmt.Errorf("missing %s column", column.name)
+		}
+	}
+
+	return nil
+}
+
+func getFileColumns(db *sql.DB) (*lib.Set, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('files')`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read files table")
+	}
+	defer rows.Close()
+
+	existing := lib.NewSet()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, errors.Wrap(err, "failed to scan")
+		}
+		existing.Set(name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read columns")
+	}
+
+	return existing, nil
+}
+
+func addMissingColumns(db *sql.DB) error {
+	existing, err := getFileColumns(db)
+	if err != nil {
+		return err
+	}
+
+	for _, column := range fileColumns {
+		if existing.Has(column.name) {
+			continue
+		}
+
+		query := fmt.Sprintf(`ALTER TABLE files ADD COLUMN %s %s`, column.name, column.definition)
+		if _, err = db.Exec(query); err != nil {
+			return errors.Wrapf(err, "failed to add %s column", column.name)
+		}
+	}
+
+	return nil
+}
+
+// underRoot matches paths in the directory given by rootArgs, but not in
+// ones that only start with the same name, since jobs can share a database.
+const underRoot = `(path = ? OR path LIKE ? ESCAPE '\')`
+
+func rootArgs(rootPath string) []any {
+	rootPath = strings.TrimRight(rootPath, "/")
+	if rootPath == "" {
+		return []any{rootPath, "%"}
+	}
+
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(rootPath)
+	return []any{rootPath, escaped + "/%"}
+}
+
 func (s *database) GetAllFiles(rootPath string) (*lib.Set, error) {
-	row, err := s.db.Query(`SELECT path FROM files WHERE path LIKE ?`, fmt.Sprintf("%s%%", rootPath))
+	row, err := s.db.Query(`SELECT path FROM files WHERE `+underRoot, rootArgs(rootPath)...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get all files")
 	}
+	defer row.Close()
 
 	var path string
 
@@ -75,7 +215,7 @@ func (s *database) Exists(path string) (bool, error) {
 func (s *database) Record(path string) error {
 	if _, err := s.db.Exec(`
 INSERT INTO files (path) VALUES (?)
-ON CONFLICT (path) DO UPDATE SET path = excluded.path
+ON CONFLICT (path) DO UPDATE SET removed_at = NULL, extracted_at = NULL
 `, path, ""); err != nil {
 		return errors.Wrapf(err, "failed to record %s", path)
 	}
@@ -83,6 +223,236 @@ ON CONFLICT (path) DO UPDATE SET path = excluded.path
 	return nil
 }
 
+func (s *database) RecordChecksum(path string, checksum lib.Checksum) error {
+	algorithm := sql.NullString{String: checksum.Algorithm, Valid: !checksum.IsEmpty()}
+	value := sql.NullString{String: checksum.Value, Valid: !checksum.IsEmpty()}
+
+	if _, err := s.db.Exec(
+		`UPDATE files SET hash_algorithm = ?, hash = ? WHERE path = ?`,
+		algorithm, value, path,
+	); err != nil {
+		return errors.Wrapf(err, "failed to record checksum for %s", path)
+	}
+
+	return nil
+}
+
+func (s *database) GetChecksum(path string) (lib.Checksum, error) {
+	var algorithm, value sql.NullString
+
+	row := s.db.QueryRow(`SELECT hash_algorithm, hash FROM files WHERE path = ?`, path)
+	err := row.Scan(&algorithm, &value)
+
+	switch err {
+	case sql.ErrNoRows:
+		return lib.Checksum{}, nil
+	case nil:
+		return lib.Checksum{Algorithm: algorithm.String, Value: value.String}, nil
+	default:
+		return lib.Checksum{}, errors.Wrapf(err, "failed to query for %s", path)
+	}
+}
+
+func (s *database) RecordModTime(path string, modTime time.Time) error {
+	if _, err := s.db.Exec(
+		`UPDATE files SET remote_mtime = ? WHERE path = ?`,
+		modTime.UTC(), path,
+	); err != nil {
+		return errors.Wrapf(err, "failed to record modification time for %s", path)
+	}
+
+	return nil
+}
+
+func (s *database) GetModTime(path string) (time.Time, error) {
+	var modTime sql.NullTime
+
+	row := s.db.QueryRow(`SELECT remote_mtime FROM files WHERE path = ?`, path)
+	err := row.Scan(&modTime)
+
+	switch err {
+	case sql.ErrNoRows:
+		return time.Time{}, nil
+	case nil:
+		return modTime.Time, nil
+	default:
+		return time.Time{}, errors.Wrapf(err, "failed to query for %s", path)
+	}
+}
+
+func toNullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+func (s *database) RecordSyncedState(path string, state lib.SyncedState) error {
+	if _, err := s.db.Exec(
+		`UPDATE files SET remote_size = ?, remote_mtime = ?, local_size = ?, local_mtime = ? WHERE path = ?`,
+		state.Remote.Size, toNullTime(state.Remote.ModTime),
+		state.Local.Size, toNullTime(state.Local.ModTime),
+		path,
+	); err != nil {
+		return errors.Wrapf(err, "failed to record state of %s", path)
+	}
+
+	return nil
+}
+
+func (s *database) GetSyncedState(path string) (lib.SyncedState, bool, error) {
+	var (
+		remoteSize, localSize   sql.NullInt64
+		remoteMTime, localMTime sql.NullTime
+	)
+
+	row := s.db.QueryRow(`SELECT remote_size, remote_mtime, local_size, local_mtime FROM files WHERE path = ?`, path)
+	err := row.Scan(&remoteSize, &remoteMTime, &localSize, &localMTime)
+
+	switch err {
+	case sql.ErrNoRows:
+		return lib.SyncedState{}, false, nil
+	case nil:
+		if !remoteSize.Valid || !localSize.Valid {
+			return lib.SyncedState{}, false, nil
+		}
+
+		return lib.SyncedState{
+			Remote: lib.FileInfo{Size: remoteSize.Int64, ModTime: remoteMTime.Time},
+			Local:  lib.FileInfo{Size: localSize.Int64, ModTime: localMTime.Time},
+		}, true, nil
+	default:
+		return lib.SyncedState{}, false, errors.Wrapf(err, "failed to query for %s", path)
+	}
+}
+
+func (s *database) RecordTombstone(path string, removedAt time.Time) error {
+	if _, err := s.db.Exec(
+		`UPDATE files SET removed_at = ? WHERE path = ?`,
+		removedAt.UTC(), path,
+	); err != nil {
+		return errors.Wrapf(err, "failed to record tombstone for %s", path)
+	}
+
+	return nil
+}
+
+func (s *database) GetTombstone(path string) (time.Time, error) {
+	var removedAt sql.NullTime
+
+	row := s.db.QueryRow(`SELECT removed_at FROM files WHERE path = ?`, path)
+	err := row.Scan(&removedAt)
+
+	switch err {
+	case sql.ErrNoRows:
+		return time.Time{}, nil
+	case nil:
+		return removedAt.Time, nil
+	default:
+		return time.Time{}, errors.Wrapf(err, "failed to query for %s", path)
+	}
+}
+
+func (s *database) RecordLocalPath(path, localPath string) error {
+	if _, err := s.db.Exec(
+		`UPDATE files SET local_path = ? WHERE path = ?`,
+		localPath, path,
+	); err != nil {
+		return errors.Wrapf(err, "failed to record local path for %s", path)
+	}
+
+	return nil
+}
+
+func (s *database) GetLocalPaths(rootPath string) (map[string]string, error) {
+	rows, err := s.db.Query(
+		`SELECT path, local_path FROM files WHERE `+underRoot+` AND local_path IS NOT NULL`,
+		rootArgs(rootPath)...,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get local paths")
+	}
+	defer rows.Close()
+
+	localPaths := make(map[string]string)
+	for rows.Next() {
+		var path, localPath string
+		if err = rows.Scan(&path, &localPath); err != nil {
+			return nil, errors.Wrap(err, "failed to scan")
+		}
+
+		localPaths[path] = localPath
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read local paths")
+	}
+
+	return localPaths, nil
+}
+
+func (s *database) RecordExtraction(archive string, files []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(
+		`UPDATE files SET extracted_at = CURRENT_TIMESTAMP WHERE path = ?`,
+		archive,
+	); err != nil {
+		return errors.Wrapf(err, "failed to record extraction of %s", archive)
+	}
+
+	for _, path := range files {
+		if _, err = tx.Exec(
+			`INSERT OR REPLACE INTO extracted (path, archive) VALUES (?, ?)`,
+			path, archive,
+		); err != nil {
+			return errors.Wrapf(err, "failed to record %s", path)
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "failed to commit extraction")
+}
+
+func (s *database) IsExtracted(archive string) (bool, error) {
+	var extractedAt sql.NullTime
+
+	row := s.db.QueryRow(`SELECT extracted_at FROM files WHERE path = ?`, archive)
+	switch err := row.Scan(&extractedAt); err {
+	case sql.ErrNoRows:
+		return false, nil
+	case nil:
+		return extractedAt.Valid, nil
+	default:
+		return false, errors.Wrapf(err, "failed to query for %s", archive)
+	}
+}
+
+func (s *database) GetExtractedFiles(rootPath string) (map[string]string, error) {
+	rows, err := s.db.Query(
+		`SELECT path, archive FROM extracted WHERE `+underRoot,
+		rootArgs(rootPath)...,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get extracted files")
+	}
+	defer rows.Close()
+
+	extracted := make(map[string]string)
+	for rows.Next() {
+		var path, archive string
+		if err = rows.Scan(&path, &archive); err != nil {
+			return nil, errors.Wrap(err, "failed to scan")
+		}
+
+		extracted[path] = archive
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read extracted files")
+	}
+
+	return extracted, nil
+}
+
 func (s *database) Delete(path string) error {
 	if _, err := s.db.Exec(
 		`DELETE FROM files WHERE path = ?`,
@@ -91,6 +461,13 @@ func (s *database) Delete(path string) error {
 		return errors.Wrap(err, "failed to delete a file")
 	}
 
+	if _, err := s.db.Exec(
+		`DELETE FROM extracted WHERE archive = ?`,
+		path,
+	); err != nil {
+		return errors.Wrap(err, "failed to forget extracted files")
+	}
+
 	return nil
 }
 
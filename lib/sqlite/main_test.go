@@ -1,7 +1,11 @@
 package sqlite
 
 import (
+	"database/sql"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/djeebus/ftpsync/lib"
 	"github.com/stretchr/testify/require"
@@ -61,3 +65,258 @@ func TestHappyPath(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, emptySet, files)
 }
+
+func TestChecksums(t *testing.T) {
+	db, err := New(":memory:")
+	require.NoError(t, err)
+
+	checksum, err := db.GetChecksum("/a")
+	require.NoError(t, err)
+	require.True(t, checksum.IsEmpty())
+
+	err = db.Record("/a")
+	require.NoError(t, err)
+
+	checksum, err = db.GetChecksum("/a")
+	require.NoError(t, err)
+	require.True(t, checksum.IsEmpty())
+
+	expected := lib.Checksum{Algorithm: lib.SHA256, Value: "abc123"}
+	err = db.RecordChecksum("/a", expected)
+	require.NoError(t, err)
+
+	checksum, err = db.GetChecksum("/a")
+	require.NoError(t, err)
+	require.Equal(t, expected, checksum)
+	require.NoError(t, db.RecordChecksum("/a", lib.Checksum{}))
+	checksum, err = db.GetChecksum("/a")
+	require.NoError(t, err)
+	require.True(t, checksum.IsEmpty())
+}
+
+func TestMigratesExistingDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ftpsync.db")
+
+	old, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = old.Exec(createFilesTable)
+	require.NoError(t, err)
+	_, err = old.Exec(`INSERT INTO files (path) VALUES ('/a')`)
+	require.NoError(t, err)
+	require.NoError(t, old.Close())
+
+	// dry runs can't migrate it, and shouldn't fail halfway through either
+	_, err = NewReadOnly(path)
+	require.ErrorContains(t, err, "run a sync first")
+
+	db, err := New(path)
+	require.NoError(t, err)
+
+	err = db.RecordChecksum("/a", lib.Checksum{Algorithm: lib.MD5, Value: "def456"})
+	require.NoError(t, err)
+
+	// opening it again must not try to add the columns twice
+	require.NoError(t, db.Close())
+	db, err = New(path)
+	require.NoError(t, err)
+
+	checksum, err := db.GetChecksum("/a")
+	require.NoError(t, err)
+	require.Equal(t, "def456", checksum.Value)
+	require.NoError(t, db.Close())
+
+	db, err = NewReadOnly(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+func TestReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ftpsync.db")
+
+	db, err := NewReadOnly(path)
+	require.NoError(t, err)
+
+	files, err := db.GetAllFiles("/")
+	require.NoError(t, err)
+	require.Equal(t, 0, files.Len())
+	require.NoError(t, db.Close())
+
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	db, err = New(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Record("/a"))
+	require.NoError(t, db.Close())
+
+	db, err = NewReadOnly(path)
+	require.NoError(t, err)
+
+	files, err = db.GetAllFiles("/")
+	require.NoError(t, err)
+	require.Equal(t, 1, files.Len())
+
+	require.Error(t, db.Record("/b"))
+}
+
+func TestModTimes(t *testing.T) {
+	db, err := New(":memory:")
+	require.NoError(t, err)
+
+	modTime, err := db.GetModTime("/a")
+	require.NoError(t, err)
+	require.True(t, modTime.IsZero())
+
+	require.NoError(t, db.Record("/a"))
+
+	modTime, err = db.GetModTime("/a")
+	require.NoError(t, err)
+	require.True(t, modTime.IsZero())
+
+	expected := time.Date(2023, 5, 31, 12, 34, 56, 0, time.FixedZone("test", 3600))
+	require.NoError(t, db.RecordModTime("/a", expected))
+
+	modTime, err = db.GetModTime("/a")
+	require.NoError(t, err)
+	require.True(t, expected.Equal(modTime), "expected %s, got %s", expected, modTime)
+}
+
+func TestSyncedState(t *testing.T) {
+	db, err := New(":memory:")
+	require.NoError(t, err)
+
+	_, ok, err := db.GetSyncedState("/a")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// files recorded before two-way sync have no state
+	require.NoError(t, db.Record("/a"))
+	_, ok, err = db.GetSyncedState("/a")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	expected := lib.SyncedState{
+		Remote: lib.FileInfo{Size: 5, ModTime: time.Date(2023, 5, 31, 12, 34, 56, 0, time.UTC)},
+		Local:  lib.FileInfo{Size: 5},
+	}
+	require.NoError(t, db.RecordSyncedState("/a", expected))
+
+	state, ok, err := db.GetSyncedState("/a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, expected.Remote.Size, state.Remote.Size)
+	require.True(t, expected.Remote.ModTime.Equal(state.Remote.ModTime))
+	require.Equal(t, expected.Local.Size, state.Local.Size)
+	require.True(t, state.Local.ModTime.IsZero())
+}
+
+func TestTombstones(t *testing.T) {
+	db, err := New(":memory:")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Record("/a"))
+
+	removedAt, err := db.GetTombstone("/a")
+	require.NoError(t, err)
+	require.True(t, removedAt.IsZero())
+
+	expected := time.Date(2023, 5, 31, 12, 34, 56, 0, time.UTC)
+	require.NoError(t, db.RecordTombstone("/a", expected))
+
+	removedAt, err = db.GetTombstone("/a")
+	require.NoError(t, err)
+	require.True(t, expected.Equal(removedAt), "expected %s, got %s", expected, removedAt)
+
+	// tombstoned files are still recorded
+	files, err := db.GetAllFiles("/")
+	require.NoError(t, err)
+	require.True(t, files.Has("/a"))
+
+	require.NoError(t, db.Record("/a"))
+	removedAt, err = db.GetTombstone("/a")
+	require.NoError(t, err)
+	require.True(t, removedAt.IsZero())
+}
+
+func TestLocalPaths(t *testing.T) {
+	db, err := New(":memory:")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Record("/movies/a.mkv"))
+	require.NoError(t, db.Record("/movies/b.mkv"))
+	require.NoError(t, db.Record("/shows/c.mkv"))
+	require.NoError(t, db.RecordLocalPath("/movies/a.mkv", "/movies/video/a.mkv"))
+	require.NoError(t, db.RecordLocalPath("/shows/c.mkv", "/shows/video/c.mkv"))
+
+	localPaths, err := db.GetLocalPaths("/movies")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/movies/a.mkv": "/movies/video/a.mkv"}, localPaths)
+}
+
+func TestExtractions(t *testing.T) {
+	db, err := New(":memory:")
+	require.NoError(t, err)
+
+	require.NoError(t, db.Record("/movies/a.zip"))
+	require.NoError(t, db.RecordExtraction("/movies/a.zip", []string{"/movies/a.mkv", "/movies/a.nfo"}))
+
+	isExtracted, err := db.IsExtracted("/movies/a.zip")
+	require.NoError(t, err)
+	require.True(t, isExtracted)
+
+	extracted, err := db.GetExtractedFiles("/movies")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/movies/a.mkv": "/movies/a.zip", "/movies/a.nfo": "/movies/a.zip"}, extracted)
+
+	// downloading it again means it needs extracting again
+	require.NoError(t, db.Record("/movies/a.zip"))
+	isExtracted, err = db.IsExtracted("/movies/a.zip")
+	require.NoError(t, err)
+	require.False(t, isExtracted)
+
+	require.NoError(t, db.Delete("/movies/a.zip"))
+	extracted, err = db.GetExtractedFiles("/movies")
+	require.NoError(t, err)
+	require.Empty(t, extracted)
+}
+
+func TestJobsWithSharedPrefixes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ftpsync.db")
+
+	// two jobs using the default database, one syncing /tv and the other
+	// /tv-archive
+	tv, err := New(path)
+	require.NoError(t, err)
+	defer tv.Close()
+	archive, err := New(path)
+	require.NoError(t, err)
+	defer archive.Close()
+
+	require.NoError(t, tv.Record("/tv/a.mkv"))
+	require.NoError(t, tv.RecordLocalPath("/tv/a.mkv", "/tv/A.mkv"))
+	require.NoError(t, tv.RecordExtraction("/tv/b.zip", []string{"/tv/b.mkv"}))
+	require.NoError(t, archive.Record("/tv-archive/c.mkv"))
+	require.NoError(t, archive.RecordLocalPath("/tv-archive/c.mkv", "/tv-archive/C.mkv"))
+	require.NoError(t, archive.RecordExtraction("/tv-archive/d.zip", []string{"/tv-archive/d.mkv"}))
+	require.NoError(t, archive.Record("/tv_x/e.mkv"))
+
+	files, err := tv.GetAllFiles("/tv")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"/tv/a.mkv"}, files.ToList())
+
+	localPaths, err := tv.GetLocalPaths("/tv/")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/tv/a.mkv": "/tv/A.mkv"}, localPaths)
+
+	extracted, err := tv.GetExtractedFiles("/tv")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"/tv/b.mkv": "/tv/b.zip"}, extracted)
+
+	files, err = archive.GetAllFiles("/tv-archive")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"/tv-archive/c.mkv"}, files.ToList())
+
+	files, err = archive.GetAllFiles("/")
+	require.NoError(t, err)
+	require.Equal(t, 3, files.Len())
+}
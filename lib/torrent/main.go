@@ -0,0 +1,105 @@
+package torrent
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+const (
+	defaultTTL = time.Minute
+
+	// missInterval is how long a file that isn't ready has to wait for the
+	// client to be asked again, so that a run full of files the client
+	// doesn't know about doesn't ask for every one of them.
+	missInterval = 5 * time.Second
+)
+
+// Client lists the files a torrent client knows about, relative to where it
+// saves them, and whether each one has finished downloading.
+type Client interface {
+	Files() (map[string]bool, error)
+}
+
+// Precheck holds back files that a torrent client is still downloading. The
+// client's files are cached for ttl, and fetched again early when a file
+// isn't ready, so files that finish during a run are picked up.
+type Precheck struct {
+	client  Client
+	log     logrus.FieldLogger
+	rootDir string
+	ttl     time.Duration
+	now     func() time.Time
+
+	lock      sync.Mutex
+	files     map[string]bool
+	fetchedAt time.Time
+}
+
+var _ lib.Precheck = new(Precheck)
+
+// New fetches the client's files, so a misconfigured client is caught early.
+func New(log logrus.FieldLogger, client Client, rootDir string, ttl time.Duration) (*Precheck, error) {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	p := &Precheck{client: client, log: log, rootDir: rootDir, ttl: ttl, now: time.Now}
+	if err := p.refresh(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Precheck) refresh() error {
+	files, err := p.client.Files()
+	if err != nil {
+		return errors.Wrap(err, "failed to list torrent files")
+	}
+
+	p.files = files
+	p.fetchedAt = p.now()
+	return nil
+}
+
+func (p *Precheck) IsFileReady(path string) (bool, error) {
+	path, err := filepath.Rel(p.rootDir, path)
+	if err != nil {
+		return false, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	age := p.now().Sub(p.fetchedAt)
+	if age >= p.ttl {
+		if err = p.refresh(); err != nil {
+			return false, err
+		}
+	}
+
+	isReady, exists := p.files[path]
+	if !isReady && age >= missInterval && age < p.ttl {
+		if err = p.refresh(); err != nil {
+			return false, err
+		}
+		isReady, exists = p.files[path]
+	}
+
+	if !exists {
+		p.log.WithField("path", path).Warning("path does not exist in torrent client")
+		return false, nil
+	}
+
+	return isReady, nil
+}
+
+func (p *Precheck) Close() error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package torrent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClient struct {
+	files map[string]bool
+	calls int
+}
+
+func (f *fakeClient) Files() (map[string]bool, error) {
+	f.calls++
+
+	files := make(map[string]bool, len(f.files))
+	for path, isReady := range f.files {
+		files[path] = isReady
+	}
+	return files, nil
+}
+
+func TestPrecheck(t *testing.T) {
+	now := time.Date(2023, 5, 31, 0, 0, 0, 0, time.UTC)
+	client := &fakeClient{files: map[string]bool{"a/done.mkv": true, "a/partial.mkv": false}}
+
+	precheck, err := New(logrus.New(), client, "/testing/", time.Minute)
+	require.NoError(t, err)
+	precheck.now = func() time.Time { return now }
+	precheck.fetchedAt = now
+	assert.Equal(t, 1, client.calls)
+
+	isReady, err := precheck.IsFileReady("/testing/a/done.mkv")
+	require.NoError(t, err)
+	assert.True(t, isReady)
+
+	// too soon to ask again
+	isReady, err = precheck.IsFileReady("/testing/a/partial.mkv")
+	require.NoError(t, err)
+	assert.False(t, isReady)
+	assert.Equal(t, 1, client.calls)
+
+	// files that finish during a run are picked up
+	client.files["a/partial.mkv"] = true
+	now = now.Add(10 * time.Second)
+	isReady, err = precheck.IsFileReady("/testing/a/partial.mkv")
+	require.NoError(t, err)
+	assert.True(t, isReady)
+	assert.Equal(t, 2, client.calls)
+
+	// ready files don't need asking about
+	now = now.Add(10 * time.Second)
+	_, err = precheck.IsFileReady("/testing/a/done.mkv")
+	require.NoError(t, err)
+	assert.Equal(t, 2, client.calls)
+
+	// but the cache still expires
+	delete(client.files, "a/done.mkv")
+	now = now.Add(time.Minute)
+	isReady, err = precheck.IsFileReady("/testing/a/done.mkv")
+	require.NoError(t, err)
+	assert.False(t, isReady)
+	assert.Equal(t, 3, client.calls)
+}
@@ -0,0 +1,64 @@
+package transmission
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiles(t *testing.T) {
+	var handshakes int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, defaultPath, r.URL.Path)
+
+		username, password, _ := r.BasicAuth()
+		if username != "admin" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Header.Get(sessionIDHeader) != "session" {
+			handshakes++
+			w.Header().Set(sessionIDHeader, "session")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		var req request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "torrent-get", req.Method)
+
+		w.Write([]byte(`{"result": "success", "arguments": {"torrents": [
+			{"files": [
+				{"name": "a/done.mkv", "length": 10, "bytesCompleted": 10},
+				{"name": "a/partial.mkv", "length": 10, "bytesCompleted": 4}
+			]},
+			{"files": [{"name": "b.mkv", "length": 5, "bytesCompleted": 5}]}
+		]}}`))
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	u.Scheme = "transmission"
+	u.User = url.UserPassword("admin", "secret")
+
+	client, err := New(logrus.New(), u)
+	require.NoError(t, err)
+
+	files, err := client.Files()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"a/done.mkv": true, "a/partial.mkv": false, "b.mkv": true}, files)
+
+	// the session id is reused
+	_, err = client.Files()
+	require.NoError(t, err)
+	assert.Equal(t, 1, handshakes)
+}
@@ -0,0 +1,282 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TwoWayKey adds what changed on each side since the file was last in
+// sync, which is how two-way sync tells a file that's new on one side from
+// one that was deleted on the other. A side only counts as changed if it
+// still has the file and the file was recorded.
+type TwoWayKey struct {
+	FileStatusKey
+	RemoteChanged bool
+	LocalChanged  bool
+}
+
+func twoWayKey(hasRemote, isRecorded, hasLocal, remoteChanged, localChanged bool) TwoWayKey {
+	return TwoWayKey{FileStatusKey{hasRemote, isRecorded, hasLocal}, remoteChanged, localChanged}
+}
+
+var twoWayActions = map[TwoWayKey]NamedAction{
+	// new on one side
+	twoWayKey(true, false, false, false, false): {downloadTwoWay, "download"},
+	twoWayKey(false, false, true, false, false): {uploadTwoWay, "upload"},
+
+	// new on both sides, and either the same size or not
+	twoWayKey(true, false, true, false, false): {recordTwoWay, "record"},
+	twoWayKey(true, false, true, true, true):   {conflictTwoWay, "conflict"},
+
+	// on both sides
+	twoWayKey(true, true, true, false, false): {skipFile, "skip"},
+	twoWayKey(true, true, true, true, false):  {downloadTwoWay, "download"},
+	twoWayKey(true, true, true, false, true):  {uploadTwoWay, "upload"},
+	twoWayKey(true, true, true, true, true):   {conflictTwoWay, "conflict"},
+
+	// deleted locally, unless it changed remotely since
+	twoWayKey(true, true, false, false, false): {deleteRemoteFile, "delete-remote"},
+	twoWayKey(true, true, false, true, false):  {downloadTwoWay, "download"},
+
+	// deleted remotely, unless it changed locally since
+	twoWayKey(false, true, true, false, false): {deleteFile, "delete"},
+	twoWayKey(false, true, true, false, true):  {uploadTwoWay, "upload"},
+
+	// deleted on both sides
+	twoWayKey(false, true, false, false, false): {deleteFile, "delete"},
+
+	twoWayKey(false, false, false, false, false): {logFile, "log"},
+}
+
+// hasChanged compares a file to what it looked like when it was last in
+// sync. Modification times are only compared when both are known.
+func hasChanged(synced, current FileInfo) bool {
+	if synced.Size != current.Size {
+		return true
+	}
+
+	if synced.ModTime.IsZero() || current.ModTime.IsZero() {
+		return false
+	}
+
+	return !synced.ModTime.Equal(current.ModTime)
+}
+
+func (p *Processor) planTwoWay(file string, remoteFiles *SizeSet, dbFiles *Set, localFiles *SizeSet) PlannedAction {
+	log := p.log.WithField("file", file)
+
+	isRecorded := dbFiles.Has(file)
+	remoteInfo, hasRemote := remoteFiles.GetInfo(file)
+	localInfo, hasLocal := localFiles.GetInfo(file)
+
+	var (
+		remoteChanged, localChanged bool
+		reason                      string
+	)
+
+	if isRecorded {
+		synced, ok, err := p.db.GetSyncedState(file)
+		if err != nil {
+			// without it, deletes and overwrites would be guesses
+			log.WithError(err).Error("failed to get synced state")
+			return PlannedAction{
+				Path:       file,
+				State:      FileStatusKey{HasRemote: hasRemote, IsRecorded: true, HasLocal: hasLocal},
+				Action:     NamedAction{logFile, "log"},
+				Reason:     "failed to get synced state",
+				RemoteSize: remoteInfo.Size,
+				LocalSize:  localInfo.Size,
+			}
+		}
+
+		switch {
+		case ok:
+			remoteChanged = hasRemote && hasChanged(synced.Remote, remoteInfo)
+			localChanged = hasLocal && hasChanged(synced.Local, localInfo)
+		case hasRemote && hasLocal:
+			// recorded by a one-way sync, which only ever leaves both
+			// sides the same
+			reason = "missing synced state"
+			remoteChanged = remoteInfo.Size != localInfo.Size
+			localChanged = remoteChanged
+		}
+	} else if hasRemote && hasLocal && remoteInfo.Size != localInfo.Size {
+		remoteChanged, localChanged = true, true
+	}
+
+	key := twoWayKey(hasRemote, isRecorded, hasLocal, remoteChanged, localChanged)
+	action := twoWayActions[key]
+	if reason != "" && action.Name == "skip" {
+		action = NamedAction{recordTwoWay, "record"}
+	}
+
+	switch {
+	case reason != "":
+	case remoteChanged && localChanged:
+		reason = "changed on both sides"
+	case remoteChanged:
+		reason = "remote changed"
+	case localChanged:
+		reason = "local changed"
+	}
+
+	return PlannedAction{
+		Path:          file,
+		State:         key.FileStatusKey,
+		Action:        action,
+		Reason:        reason,
+		RemoteSize:    remoteInfo.Size,
+		LocalSize:     localInfo.Size,
+		RemoteChanged: remoteChanged,
+		LocalChanged:  localChanged,
+	}
+}
+
+// reversed returns a copy of p that copies files from local to remote.
+func (p *Processor) reversed() *Processor {
+	reversed := *p
+	reversed.remote = p.local.(Source)
+	reversed.local = p.remote.(Destination)
+	reversed.remoteFiles = p.localFiles
+	reversed.localFiles = p.remoteFiles
+	// the precheck only knows about the remote side
+	reversed.precheck = nil
+	return &reversed
+}
+
+// stat looks up path on side, or returns fallback if side can't do that.
+func stat(side any, path string, fallback FileInfo) (FileInfo, error) {
+	stater, ok := side.(Stater)
+	if !ok {
+		return fallback, nil
+	}
+
+	return stater.Stat(path)
+}
+
+// recordSyncedState records what both sides look like now that path was
+// copied from one to the other.
+func (p *Processor) recordSyncedState(path string, remoteWritten bool) error {
+	remoteInfo, _ := p.remoteFiles.GetInfo(path)
+	localInfo, _ := p.localFiles.GetInfo(path)
+
+	var err error
+	if remoteWritten {
+		remoteInfo, err = stat(p.remote, path, FileInfo{Size: localInfo.Size})
+	} else {
+		localInfo, err = stat(p.local, path, FileInfo{Size: remoteInfo.Size})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to look up %s", path)
+	}
+
+	if err = p.db.Record(path); err != nil {
+		return errors.Wrapf(err, "failed to record %s", path)
+	}
+
+	if err = p.db.RecordSyncedState(path, SyncedState{Remote: remoteInfo, Local: localInfo}); err != nil {
+		return errors.Wrapf(err, "failed to record state of %s", path)
+	}
+
+	return nil
+}
+
+func downloadTwoWay(_ FileStatusKey, p *Processor, path string) error {
+	ok, err := p.transfer(path)
+	if err != nil || !ok {
+		return err
+	}
+
+	if err = p.recordSyncedState(path, false); err != nil {
+		return err
+	}
+
+	p.fileDownloaded(path)
+	return nil
+}
+
+func uploadTwoWay(_ FileStatusKey, p *Processor, path string) error {
+	ok, err := p.reversed().transfer(path)
+	if err != nil || !ok {
+		return err
+	}
+
+	return p.recordSyncedState(path, true)
+}
+
+func recordTwoWay(_ FileStatusKey, p *Processor, path string) error {
+	p.log.WithField("path", path).Info("recording")
+
+	remoteInfo, _ := p.remoteFiles.GetInfo(path)
+	localInfo, _ := p.localFiles.GetInfo(path)
+
+	if err := p.db.Record(path); err != nil {
+		return errors.Wrapf(err, "failed to record %s", path)
+	}
+
+	if err := p.db.RecordSyncedState(path, SyncedState{Remote: remoteInfo, Local: localInfo}); err != nil {
+		return errors.Wrapf(err, "failed to record state of %s", path)
+	}
+
+	return nil
+}
+
+// conflictPath is where the local copy of path is kept when both sides
+// changed.
+func conflictPath(path string, now time.Time) string {
+	return fmt.Sprintf("%s.conflict-%s", path, now.UTC().Format("20060102T150405Z"))
+}
+
+// conflictTwoWay moves the local copy aside and downloads the remote one.
+// The local copy is uploaded as a new file on the next run, so both sides
+// end up with both versions. If the download doesn't happen, the local copy
+// is moved back, so the next run sees the same conflict.
+func conflictTwoWay(_ FileStatusKey, p *Processor, path string) error {
+	renamer, ok := p.local.(Renamer)
+	if !ok {
+		return fmt.Errorf("cannot keep both copies of %s, the destination can't rename files", path)
+	}
+
+	newPath := conflictPath(path, time.Now())
+	p.log.
+		WithField("path", path).
+		WithField("conflict", newPath).
+		Warning("file changed on both sides, keeping both copies")
+
+	if err := renamer.Rename(path, newPath); err != nil {
+		return errors.Wrapf(err, "failed to move %s aside", path)
+	}
+
+	ok, err := p.transfer(path)
+	if err != nil || !ok {
+		if restoreErr := renamer.Rename(newPath, path); restoreErr != nil {
+			p.log.WithError(restoreErr).WithField("path", path).Error("failed to restore local copy")
+		}
+		return err
+	}
+
+	if err = p.recordSyncedState(path, false); err != nil {
+		return err
+	}
+
+	p.fileDownloaded(path)
+	return nil
+}
+
+func deleteRemoteFile(_ FileStatusKey, p *Processor, path string) error {
+	log := p.log.WithField("path", path)
+
+	log.Info("deleting remote file")
+	if err := p.remote.(Destination).Delete(path); err != nil {
+		return errors.Wrap(err, "error deleting remote file")
+	}
+
+	log.Info("deleting record")
+	if err := p.db.Delete(path); err != nil {
+		return errors.Wrap(err, "error unrecording file")
+	}
+
+	return nil
+}
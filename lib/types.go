@@ -1,9 +1,13 @@
 package lib
 
-import "io"
+import (
+	"io"
+	"time"
+)
 
 type Source interface {
-	Read(path string) (io.ReadCloser, error)
+	// Read opens path for reading, skipping the first offset bytes.
+	Read(path string, offset int64) (io.ReadCloser, error)
 	GetAllFiles(path string) (*SizeSet, error)
 	Close() error
 }
@@ -15,16 +19,139 @@ type Precheck interface {
 
 type Destination interface {
 	GetAllFiles(path string) (*SizeSet, error)
+	// GetPartialSize returns how many bytes of path were written by a
+	// previous, interrupted Write.
+	GetPartialSize(path string) (int64, error)
 	Delete(path string) error
 	Exists(path string) (bool, error)
-	Write(path string, fp io.ReadCloser) (int64, error)
+	// Write appends fp to the partial file for path, starting at offset,
+	// and moves it into place once fp is exhausted.
+	Write(path string, offset int64, fp io.ReadCloser) (int64, error)
 	CleanDirectories(path string) error
 }
 
+// Metrics is told what happens during every sync, so it can be exported
+// for monitoring.
+type Metrics interface {
+	// PlanCreated is called with every plan before it's executed.
+	PlanCreated(plan *Plan)
+	// ActionFinished is called after every action, with its error, if any.
+	ActionFinished(action string, err error)
+	// Downloaded is called with the number of bytes written by a download,
+	// even if it failed partway through.
+	Downloaded(bytes int64)
+	// DeletesBlocked is called when a plan is not executed because it
+	// would have deleted too many files.
+	DeletesBlocked(deletes int)
+	// SyncFinished is called at the end of every Process.
+	SyncFinished(duration time.Duration, err error)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) PlanCreated(*Plan)                 {}
+func (noopMetrics) ActionFinished(string, error)      {}
+func (noopMetrics) Downloaded(int64)                  {}
+func (noopMetrics) DeletesBlocked(int)                {}
+func (noopMetrics) SyncFinished(time.Duration, error) {}
+
+// Hook is told about every downloaded file and every finished sync, so it
+// can tell other programs about them. Errors are logged, and never fail the
+// sync.
+type Hook interface {
+	FileDownloaded(event FileEvent) error
+	SyncFinished(event SyncEvent) error
+}
+
+type FileEvent struct {
+	// Path is where the file is on the source, and LocalPath is where it
+	// was written on the destination.
+	Path      string
+	LocalPath string
+	Size      int64
+}
+
+type SyncEvent struct {
+	Root       string
+	Downloaded int64
+	Failed     int64
+	Duration   time.Duration
+	// Error is empty if the sync succeeded.
+	Error string
+}
+
+// ModTimeSetter is implemented by destinations that can set the
+// modification time of a file.
+type ModTimeSetter interface {
+	SetModTime(path string, modTime time.Time) error
+}
+
+// Extractor is implemented by destinations that can unpack archives.
+type Extractor interface {
+	IsArchive(path string) bool
+	// Extract unpacks the archive at path next to it, and returns the paths
+	// of the files it wrote. Nothing is left behind if it fails.
+	Extract(path string) ([]string, error)
+}
+
+// Discarder is implemented by destinations where Delete might keep the
+// file somewhere, like a trash, so files the sync wrote itself and can't
+// use can be removed for good instead.
+type Discarder interface {
+	Discard(path string) error
+}
+
+// Renamer is implemented by destinations that can move a file.
+type Renamer interface {
+	Rename(from, to string) error
+}
+
+// Stater is implemented by sources and destinations that can look up a
+// single file without listing everything.
+type Stater interface {
+	Stat(path string) (FileInfo, error)
+}
+
+// SyncedState is what a file looked like on both sides the last time they
+// were in sync, which is how two-way sync tells which side changed.
+type SyncedState struct {
+	Remote FileInfo
+	Local  FileInfo
+}
+
 type Database interface {
 	GetAllFiles(path string) (*Set, error)
 	Exists(path string) (bool, error)
 	Record(path string) error
+	// RecordChecksum forgets the checksum of path if checksum is empty.
+	RecordChecksum(path string, checksum Checksum) error
+	// GetChecksum returns an empty Checksum if none was recorded for path.
+	GetChecksum(path string) (Checksum, error)
+	// RecordModTime stores the remote modification time of path.
+	RecordModTime(path string, modTime time.Time) error
+	// GetModTime returns a zero time if none was recorded for path.
+	GetModTime(path string) (time.Time, error)
+	RecordSyncedState(path string, state SyncedState) error
+	// GetSyncedState returns false if no state was recorded for path.
+	GetSyncedState(path string) (SyncedState, bool, error)
+	// RecordTombstone marks path as removed from the source at removedAt.
+	// Recording path again clears it.
+	RecordTombstone(path string, removedAt time.Time) error
+	// GetTombstone returns a zero time if path wasn't removed.
+	GetTombstone(path string) (time.Time, error)
+	// RecordLocalPath stores where path was put on the destination, when
+	// it was renamed.
+	RecordLocalPath(path, localPath string) error
+	// GetLocalPaths returns the recorded local path of every file under
+	// rootPath that has one.
+	GetLocalPaths(rootPath string) (map[string]string, error)
+	// RecordExtraction marks archive as extracted, into files. Recording
+	// archive again clears the mark, but not the files.
+	RecordExtraction(archive string, files []string) error
+	IsExtracted(archive string) (bool, error)
+	// GetExtractedFiles returns the archive every extracted file under
+	// rootPath came from. Deleting an archive forgets its files.
+	GetExtractedFiles(rootPath string) (map[string]string, error)
 	Delete(path string) error
 	Close() error
 }
@@ -0,0 +1,131 @@
+package webdav
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+
+	"github.com/djeebus/ftpsync/lib"
+)
+
+// newTestServer serves root over webdav under /dav, to either basic auth or
+// a bearer token.
+func newTestServer(t *testing.T, root string) *httptest.Server {
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.Dir(root),
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !(ok && username == "user" && password == "pass") && r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestSource(t *testing.T, server *httptest.Server, auth string) *WebDAV {
+	u, err := url.Parse(strings.Replace(server.URL, "http://", "webdav://"+auth, 1) + "/dav/")
+	require.NoError(t, err)
+
+	src, err := New(u, nil, logrus.New())
+	require.NoError(t, err)
+	t.Cleanup(func() { src.Close() })
+
+	return src
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(root, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestGetAllFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"movies/a.mkv":                  "aaa",
+		"movies/b c/d.mkv":              "dddd",
+		lib.PartialPath("movies/e.mkv"): "partial",
+		"shows/f.mkv":                   "f",
+	})
+
+	modTime := time.Date(2023, 5, 31, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "movies", "a.mkv"), modTime, modTime))
+
+	for name, auth := range map[string]string{"basic": "user:pass@", "bearer": ""} {
+		t.Run(name, func(t *testing.T) {
+			server := newTestServer(t, root)
+			src := newTestSource(t, server, auth)
+			if name == "bearer" {
+				src.token = "secret"
+			}
+
+			files, err := src.GetAllFiles("/movies")
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"/movies/a.mkv", "/movies/b c/d.mkv"}, files.ToSet().ToList())
+
+			info, _ := files.GetInfo("/movies/a.mkv")
+			assert.Equal(t, int64(3), info.Size)
+			assert.True(t, modTime.Equal(info.ModTime))
+		})
+	}
+}
+
+func TestBearerTokenFromURL(t *testing.T) {
+	u, err := url.Parse("webdavs://host/dav?token=secret")
+	require.NoError(t, err)
+
+	src, err := New(u, nil, logrus.New())
+	require.NoError(t, err)
+	assert.Equal(t, "secret", src.token)
+	assert.Equal(t, "https://host/dav/a%20b.mkv", src.toURL("/a b.mkv", false))
+}
+
+func TestUnauthorized(t *testing.T) {
+	server := newTestServer(t, t.TempDir())
+	src := newTestSource(t, server, "user:wrong@")
+
+	_, err := src.GetAllFiles("/")
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestRead(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"a b.mkv": "hello world"})
+
+	server := newTestServer(t, root)
+	src := newTestSource(t, server, "user:pass@")
+
+	for offset, expected := range map[int64]string{0: "hello world", 6: "world"} {
+		fp, err := src.Read("/a b.mkv", offset)
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(fp)
+		require.NoError(t, err)
+		require.NoError(t, fp.Close())
+		assert.Equal(t, expected, string(content))
+	}
+
+	_, err := src.Read("/missing.mkv", 0)
+	assert.Error(t, err)
+}